@@ -0,0 +1,48 @@
+package verifiedsms
+
+import (
+	"context"
+	"github.com/monzo/terrors"
+)
+
+// AgentClient marks SMS as verified as a single agent, so the agent doesn't need to be passed to every call
+type AgentClient struct {
+	partner Partner
+	agent   *Agent
+}
+
+// WithAgent returns an AgentClient which verifies SMS as agent, checking once that the agent has an ID and a P-384
+// private key
+func (partner Partner) WithAgent(agent *Agent) (*AgentClient, error) {
+	err := agent.validate(partner.HashingOptions)
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
+
+	return &AgentClient{
+		partner: partner,
+		agent:   agent,
+	}, nil
+}
+
+// Agent returns the agent SMS are verified as
+func (client *AgentClient) Agent() *Agent {
+	return client.agent
+}
+
+// MarkSMSAsVerified marks a given SMS as verified for a given end users phone number, see Partner.MarkSMSAsVerified
+func (client *AgentClient) MarkSMSAsVerified(ctx context.Context, phoneNumber string, smsMessage string) (bool, error) {
+	return client.partner.MarkSMSAsVerified(ctx, phoneNumber, client.agent, smsMessage)
+}
+
+// MarkSMSAsVerifiedResult marks a given SMS as verified for a given end users phone number and describes the outcome,
+// see Partner.MarkSMSAsVerifiedResult
+func (client *AgentClient) MarkSMSAsVerifiedResult(ctx context.Context, phoneNumber string, smsMessage string) (*VerificationResult, error) {
+	return client.partner.MarkSMSAsVerifiedResult(ctx, phoneNumber, client.agent, smsMessage)
+}
+
+// DeleteVerifiedMessages withdraws verification of a given SMS for a given end users phone number, see
+// Partner.DeleteVerifiedMessages
+func (client *AgentClient) DeleteVerifiedMessages(ctx context.Context, phoneNumber string, smsMessage string) error {
+	return client.partner.DeleteVerifiedMessages(ctx, client.agent, smsMessage, phoneNumber)
+}
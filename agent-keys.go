@@ -0,0 +1,144 @@
+package verifiedsms
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"github.com/monzo/terrors"
+	"github.com/monzo/verifiedsms/hashing"
+	"strings"
+	"unicode"
+)
+
+// NewAgentFromPEM returns an Agent with the given ID and the P-384 EC private key PEM encoded in pemBytes, in either
+// SEC 1 ("EC PRIVATE KEY") or PKCS #8 ("PRIVATE KEY") form
+func NewAgentFromPEM(id string, pemBytes []byte) (*Agent, error) {
+	if id == "" {
+		return nil, terrors.BadRequest(ErrInvalidArgument, "agent ID must not be empty", nil)
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, terrors.BadRequest(ErrInvalidArgument, "agent private key could not be decoded as PEM", map[string]string{
+			"agent_id": id,
+		})
+	}
+
+	privateKey, err := parseAgentPrivateKey(block)
+	if err != nil {
+		return nil, terrors.Augment(err, "failed to parse agent private key", map[string]string{
+			"agent_id": id,
+		})
+	}
+
+	agent := &Agent{
+		ID:         id,
+		PrivateKey: privateKey,
+	}
+
+	err = agent.validate(hashing.Options{})
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
+
+	return agent, nil
+}
+
+// validate checks the agent has a well-formed ID and that its private key and any previous private keys are on a curve
+// options approves, which is only P-384 for the zero value
+// Keys are checked by options.ValidatePrivateKey, so a key on the wrong curve fails with the same error whether it is
+// caught here or while hashing
+func (agent *Agent) validate(options hashing.Options) error {
+	if agent == nil {
+		return terrors.BadRequest(ErrInvalidArgument, "agent must not be nil", nil)
+	}
+
+	err := validateAgentID(agent.ID)
+	if err != nil {
+		return terrors.Propagate(err)
+	}
+
+	for _, privateKey := range append([]*ecdsa.PrivateKey{agent.PrivateKey}, agent.PreviousPrivateKeys...) {
+		err := options.ValidatePrivateKey(privateKey)
+		if err != nil {
+			return terrors.Augment(err, "invalid agent private key", map[string]string{
+				"agent_id": agent.ID,
+			})
+		}
+	}
+
+	return nil
+}
+
+// validateAgentID checks id looks like a Verified SMS agent ID, so a bad ID is caught locally rather than Google
+// rejecting a whole batch of hashes with an unclear error
+// Agent IDs are the last segment of the agent's resource name, e.g. "my-agent" rather than "agents/my-agent"
+func validateAgentID(id string) error {
+	if id == "" {
+		return terrors.BadRequest(ErrInvalidArgument, "agent ID must not be empty", nil)
+	}
+
+	if strings.Contains(id, "/") {
+		return terrors.BadRequest(ErrInvalidArgument, "agent ID must not contain a /, it should be the ID rather than "+
+			"the agent's resource name", map[string]string{
+			"agent_id": id,
+		})
+	}
+
+	if strings.IndexFunc(id, unicode.IsSpace) != -1 {
+		return terrors.BadRequest(ErrInvalidArgument, "agent ID must not contain whitespace", map[string]string{
+			"agent_id": id,
+		})
+	}
+
+	return nil
+}
+
+// parseAgentPrivateKey parses an EC private key from a SEC 1 or PKCS #8 PEM block
+func parseAgentPrivateKey(block *pem.Block) (*ecdsa.PrivateKey, error) {
+	if block.Type == "EC PRIVATE KEY" {
+		privateKey, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, terrors.BadRequest(ErrInvalidArgument, "agent private key is not a valid EC private key", map[string]string{
+				"error": err.Error(),
+			})
+		}
+
+		return privateKey, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, terrors.BadRequest(ErrInvalidArgument, "agent private key is not a valid PKCS #8 private key", map[string]string{
+			"pem_type": block.Type,
+			"error":    err.Error(),
+		})
+	}
+
+	privateKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, terrors.BadRequest(ErrInvalidArgument, "agent private key is not an EC private key", map[string]string{
+			"pem_type": block.Type,
+		})
+	}
+
+	return privateKey, nil
+}
+
+// PublicKeyPKIXBase64 returns the agent's public key as base64 encoded PKIX, the form Google expects when registering
+// the agent and the form hashing.ParsePublicKey parses
+func (agent *Agent) PublicKeyPKIXBase64() (string, error) {
+	if agent == nil || agent.PrivateKey == nil {
+		return "", terrors.PreconditionFailed(terrors.ErrPreconditionFailed, "agent private key must not be nil", nil)
+	}
+
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(agent.PrivateKey.Public())
+	if err != nil {
+		return "", terrors.Augment(err, "failed to marshal agent public key", map[string]string{
+			"agent_id": agent.ID,
+		})
+	}
+
+	return base64.StdEncoding.EncodeToString(publicKeyBytes), nil
+}
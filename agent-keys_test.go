@@ -0,0 +1,133 @@
+package verifiedsms
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/monzo/verifiedsms/hashing"
+)
+
+func TestAgentValidateRejectsKeysOnTheWrongCurveLikeHashing(t *testing.T) {
+	privateKey := newTestPrivateKey(t, elliptic.P256())
+
+	agent := &Agent{
+		ID:         "test-agent",
+		PrivateKey: privateKey,
+	}
+
+	agentErr := agent.validate(hashing.Options{})
+	if !terrors.Is(agentErr, terrors.ErrPreconditionFailed) {
+		t.Fatalf("expected a precondition failed error, got %v", agentErr)
+	}
+
+	hashingErr := hashing.ValidatePrivateKey(privateKey)
+	if agentErr.(*terrors.Error).Code != hashingErr.(*terrors.Error).Code {
+		t.Errorf("expected the same code as hashing, got %s and %s",
+			agentErr.(*terrors.Error).Code, hashingErr.(*terrors.Error).Code)
+	}
+
+	if agentErr.(*terrors.Error).Params["agent_id"] != "test-agent" {
+		t.Errorf("expected the error to name the agent, got %v", agentErr.(*terrors.Error).Params)
+	}
+}
+
+func TestAgentValidateRejectsPreviousKeysOnTheWrongCurve(t *testing.T) {
+	agent := newTestAgent(t)
+	agent.PreviousPrivateKeys = append(agent.PreviousPrivateKeys, newTestPrivateKey(t, elliptic.P256()))
+
+	if err := agent.validate(hashing.Options{}); !terrors.Is(err, terrors.ErrPreconditionFailed) {
+		t.Errorf("expected a precondition failed error, got %v", err)
+	}
+}
+
+func marshalTestPrivateKey(t *testing.T, privateKey *ecdsa.PrivateKey, pkcs8 bool) []byte {
+	t.Helper()
+
+	if pkcs8 {
+		der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			t.Fatalf("failed to marshal key: %v", err)
+		}
+
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	}
+
+	der, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func TestNewAgentFromPEMAcceptsP384Keys(t *testing.T) {
+	privateKey := newTestPrivateKey(t, elliptic.P384())
+
+	for name, pkcs8 := range map[string]bool{"SEC 1": false, "PKCS #8": true} {
+		t.Run(name, func(t *testing.T) {
+			agent, err := NewAgentFromPEM("test-agent", marshalTestPrivateKey(t, privateKey, pkcs8))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if agent.ID != "test-agent" || !agent.PrivateKey.Equal(privateKey) {
+				t.Errorf("expected the agent to have the ID and key, got %+v", agent)
+			}
+		})
+	}
+}
+
+func TestNewAgentFromPEMRejectsP256Keys(t *testing.T) {
+	privateKey := newTestPrivateKey(t, elliptic.P256())
+
+	for name, pkcs8 := range map[string]bool{"SEC 1": false, "PKCS #8": true} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewAgentFromPEM("test-agent", marshalTestPrivateKey(t, privateKey, pkcs8))
+			if !terrors.Is(err, terrors.ErrPreconditionFailed) {
+				t.Errorf("expected a P-256 key to be rejected, got %v", err)
+			}
+		})
+	}
+}
+
+func TestNewAgentFromPEMRejectsInvalidPEM(t *testing.T) {
+	if _, err := NewAgentFromPEM("test-agent", []byte("not a key")); !terrors.Is(err, terrors.ErrBadRequest) {
+		t.Errorf("expected a bad request, got %v", err)
+	}
+}
+
+func TestInvalidAgentIDFailsWithoutCallingGoogle(t *testing.T) {
+	testCases := map[string]string{
+		"empty":         "",
+		"resource name": "agents/test-agent",
+		"whitespace":    "test agent",
+	}
+
+	for name, agentID := range testCases {
+		t.Run(name, func(t *testing.T) {
+			google := newFakeGoogle(map[string][]string{
+				"+447700900001": {newTestUserKey(t)},
+			})
+			partner := newTestPartner(t, google)
+
+			agent := newTestAgent(t)
+			agent.ID = agentID
+
+			_, err := partner.MarkSMSAsVerified(context.Background(), "+447700900001", agent, "Your code is 1234")
+			if !terrors.Is(err, terrors.ErrBadRequest) {
+				t.Errorf("expected a bad request, got %v", err)
+			}
+
+			for _, path := range []string{apiGetPublicKeysPath, apiSubmitHashesPath} {
+				if count := google.requestCount(path); count != 0 {
+					t.Errorf("expected no requests to %s, got %d", path, count)
+				}
+			}
+		})
+	}
+}
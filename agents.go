@@ -0,0 +1,208 @@
+package verifiedsms
+
+import (
+	"context"
+	"github.com/monzo/terrors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// AgentStatus describes the registration of a Verified SMS agent
+type AgentStatus struct {
+	// ID is the ID of the agent
+	ID string
+
+	// Enabled is true if the agent is registered and able to verify messages
+	Enabled bool
+
+	// DisplayName is the name shown to users for messages verified by the agent
+	DisplayName string
+
+	// Brand is the brand the agent belongs to
+	Brand string
+}
+
+// GetAgentStatus gets the registration status of the agent with the given ID from the Verified SMS service, e.g. so a
+// deploy can check the agent is healthy before sending verified messages
+func (partner Partner) GetAgentStatus(ctx context.Context, agentID string) (*AgentStatus, error) {
+	err := validateAgentID(agentID)
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
+
+	response := agentResponse{}
+
+	_, err = partner.doRequest(ctx, http.MethodGet, apiAgentsPath+"/"+url.PathEscape(agentID), nil, &response)
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
+
+	return &AgentStatus{
+		ID:          agentID,
+		Enabled:     response.State == agentStateEnabled,
+		DisplayName: response.DisplayName,
+		Brand:       response.Brand,
+	}, nil
+}
+
+// DefaultMaxListPages is the most pages of results fetched by ListAgents and AgentPages unless the Partner's
+// MaxListPages is set
+const DefaultMaxListPages = 100
+
+// ListAgents lists every agent the Partner can verify messages as on the Verified SMS service, fetching every page of
+// results, e.g. to audit which brands are live
+// At most the Partner's MaxListResults agents are returned if it's set, and an error is returned if there are more
+// pages than its MaxListPages. The agents fetched before an error are returned along with it, so callers can still use
+// a partial listing
+func (partner Partner) ListAgents(ctx context.Context) ([]AgentStatus, error) {
+	var agents []AgentStatus
+
+	pages := partner.AgentPages()
+	for pages.Next(ctx) {
+		agents = append(agents, pages.Agents()...)
+	}
+
+	if err := pages.Err(); err != nil {
+		return agents, terrors.Augment(err, "failed to list agents", map[string]string{
+			"agent_count": strconv.Itoa(len(agents)),
+		})
+	}
+
+	return agents, nil
+}
+
+// AgentPages iterates over the agents the Partner can verify messages as a page at a time, so they can be processed
+// as they're fetched rather than all at once
+//
+//	pages := partner.AgentPages()
+//	for pages.Next(ctx) {
+//		for _, agent := range pages.Agents() {
+//			...
+//		}
+//	}
+//	if err := pages.Err(); err != nil {
+//		...
+//	}
+//
+// It isn't safe for concurrent use
+type AgentPages struct {
+	partner Partner
+
+	pageToken string
+	pages     int
+	results   int
+	done      bool
+
+	agents []AgentStatus
+	err    error
+}
+
+// AgentPages returns an iterator over the pages of agents the Partner can verify messages as, which follows Google's
+// page tokens until every page has been fetched
+func (partner Partner) AgentPages() *AgentPages {
+	return &AgentPages{
+		partner: partner,
+	}
+}
+
+// Next fetches the next page of agents, returning false once every page has been fetched, the Partner's
+// MaxListResults is reached or an error occurs
+func (pages *AgentPages) Next(ctx context.Context) bool {
+	pages.agents = nil
+
+	if pages.done || pages.err != nil {
+		return false
+	}
+
+	if pages.pages >= pages.partner.maxListPages() {
+		pages.err = terrors.PreconditionFailed(terrors.ErrPreconditionFailed, "there are more pages of agents than the maximum", map[string]string{
+			"max_list_pages": strconv.Itoa(pages.partner.maxListPages()),
+		})
+		return false
+	}
+
+	path := apiAgentsPath
+	if pages.pageToken != "" {
+		path += "?" + url.Values{"pageToken": []string{pages.pageToken}}.Encode()
+	}
+
+	response := listAgentsResponse{}
+
+	_, err := pages.partner.doRequest(ctx, http.MethodGet, path, nil, &response)
+	if err != nil {
+		pages.err = terrors.Augment(err, "failed to fetch page of agents", map[string]string{
+			"page": strconv.Itoa(pages.pages),
+		})
+		return false
+	}
+
+	pages.pages++
+
+	for _, agent := range response.Agents {
+		if pages.reachedMaxResults() {
+			break
+		}
+
+		pages.agents = append(pages.agents, AgentStatus{
+			ID:          strings.TrimPrefix(agent.Name, agentNamePrefix),
+			Enabled:     agent.State == agentStateEnabled,
+			DisplayName: agent.DisplayName,
+			Brand:       agent.Brand,
+		})
+		pages.results++
+	}
+
+	// A repeated page token would return the same page forever
+	if response.NextPageToken == "" || response.NextPageToken == pages.pageToken || pages.reachedMaxResults() {
+		pages.done = true
+	}
+
+	pages.pageToken = response.NextPageToken
+
+	return len(pages.agents) > 0 || !pages.done
+}
+
+// reachedMaxResults reports whether the Partner's MaxListResults agents have been returned
+func (pages *AgentPages) reachedMaxResults() bool {
+	return pages.partner.MaxListResults > 0 && pages.results >= pages.partner.MaxListResults
+}
+
+// Agents returns the page of agents fetched by the last call to Next
+func (pages *AgentPages) Agents() []AgentStatus {
+	return pages.agents
+}
+
+// Err returns the error which stopped the iteration, if any
+func (pages *AgentPages) Err() error {
+	return pages.err
+}
+
+// maxListPages returns the most pages of results the Partner fetches from a list endpoint
+func (partner Partner) maxListPages() int {
+	if partner.MaxListPages < 1 {
+		return DefaultMaxListPages
+	}
+
+	return partner.MaxListPages
+}
+
+const (
+	agentStateEnabled = "ENABLED"
+
+	// agentNamePrefix prefixes agent IDs in the resource names Google returns
+	agentNamePrefix = "agents/"
+)
+
+type agentResponse struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+	Brand       string `json:"brand"`
+	State       string `json:"state"`
+}
+
+type listAgentsResponse struct {
+	Agents        []agentResponse `json:"agents"`
+	NextPageToken string          `json:"nextPageToken"`
+}
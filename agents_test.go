@@ -0,0 +1,122 @@
+package verifiedsms
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/monzo/terrors"
+)
+
+// agentPagesHandler serves pages of agents, one per request, recording the page token of each request. The last page
+// is served again for any requests after it
+func agentPagesHandler(t *testing.T, pages []string, pageTokens *[]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != apiAgentsPath {
+			t.Errorf("expected a request to %s, got %s", apiAgentsPath, r.URL.Path)
+			return
+		}
+
+		*pageTokens = append(*pageTokens, r.URL.Query().Get("pageToken"))
+
+		page := len(*pageTokens) - 1
+		if page >= len(pages) {
+			page = len(pages) - 1
+		}
+
+		_, _ = w.Write([]byte(pages[page]))
+	}
+}
+
+func TestListAgentsFollowsPageTokens(t *testing.T) {
+	var pageTokens []string
+	partner := newTestPartner(t, agentPagesHandler(t, []string{
+		`{"agents":[{"name":"agents/first","state":"ENABLED","displayName":"First","brand":"Monzo"}],"nextPageToken":"page-2"}`,
+		`{"agents":[{"name":"agents/second","state":"DISABLED","displayName":"Second","brand":"Monzo"}]}`,
+	}, &pageTokens))
+
+	agents, err := partner.ListAgents(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []AgentStatus{
+		{ID: "first", Enabled: true, DisplayName: "First", Brand: "Monzo"},
+		{ID: "second", Enabled: false, DisplayName: "Second", Brand: "Monzo"},
+	}
+	if !reflect.DeepEqual(agents, expected) {
+		t.Errorf("expected %v, got %v", expected, agents)
+	}
+
+	if !reflect.DeepEqual(pageTokens, []string{"", "page-2"}) {
+		t.Errorf("expected the second page to be requested with the first page's token, got %q", pageTokens)
+	}
+}
+
+func TestListAgentsReturnsFetchedAgentsWhenMaxListPagesIsReached(t *testing.T) {
+	var pageTokens []string
+	partner := newTestPartner(t, agentPagesHandler(t, []string{
+		`{"agents":[{"name":"agents/first","state":"ENABLED"}],"nextPageToken":"page-2"}`,
+		`{"agents":[{"name":"agents/second","state":"ENABLED"}],"nextPageToken":"page-3"}`,
+		`{"agents":[{"name":"agents/third","state":"ENABLED"}],"nextPageToken":"page-4"}`,
+	}, &pageTokens))
+	partner.MaxListPages = 2
+
+	agents, err := partner.ListAgents(context.Background())
+	if !terrors.Is(err, terrors.ErrPreconditionFailed) {
+		t.Fatalf("expected a precondition failed error, got %v", err)
+	}
+
+	expected := []AgentStatus{
+		{ID: "first", Enabled: true},
+		{ID: "second", Enabled: true},
+	}
+	if !reflect.DeepEqual(agents, expected) {
+		t.Errorf("expected the agents already fetched, got %v", agents)
+	}
+
+	if len(pageTokens) != 2 {
+		t.Errorf("expected 2 pages to be fetched, got %d", len(pageTokens))
+	}
+
+	if err.(*terrors.Error).Params["agent_count"] != "2" {
+		t.Errorf("expected the agent count, got %v", err.(*terrors.Error).Params)
+	}
+}
+
+func TestAgentPagesStopsOnARepeatedPageToken(t *testing.T) {
+	var pageTokens []string
+	partner := newTestPartner(t, agentPagesHandler(t, []string{
+		`{"agents":[{"name":"agents/first","state":"ENABLED"}],"nextPageToken":"page-2"}`,
+		`{"agents":[{"name":"agents/second","state":"ENABLED"}],"nextPageToken":"page-2"}`,
+	}, &pageTokens))
+
+	var agents []AgentStatus
+
+	pages := partner.AgentPages()
+	for pages.Next(context.Background()) {
+		agents = append(agents, pages.Agents()...)
+	}
+
+	if err := pages.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(agents) != 2 || len(pageTokens) != 2 {
+		t.Errorf("expected 2 agents from 2 pages, got %v from %d pages", agents, len(pageTokens))
+	}
+}
+
+func TestGetAgentStatusRejectsInvalidAgentIDsWithoutCallingGoogle(t *testing.T) {
+	partner := newTestPartner(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("expected no request to Google, got %s", r.URL.Path)
+	}))
+
+	for _, agentID := range []string{"", "agents/test-agent", "test agent"} {
+		_, err := partner.GetAgentStatus(context.Background(), agentID)
+		if !terrors.Is(err, terrors.ErrBadRequest, ErrInvalidArgument) {
+			t.Errorf("expected %q to be an invalid argument, got %v", agentID, err)
+		}
+	}
+}
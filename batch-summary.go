@@ -0,0 +1,120 @@
+package verifiedsms
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// BatchSummary summarises a batch operation, e.g. to print a report at the end of a campaign
+type BatchSummary struct {
+	// Verified is the number of phone numbers the SMS was marked as verified for, including partially verified numbers
+	Verified int
+
+	// NotSupported is the number of phone numbers whose devices don't support Verified SMS
+	NotSupported int
+
+	// Failed is the number of phone numbers that couldn't be verified because of an error
+	Failed int
+
+	// APICalls is the number of requests made to Google, including retries of the same request
+	APICalls int
+
+	// Duration is how long the operation took
+	Duration time.Duration
+}
+
+// MarkSMSAsVerifiedForNumbersWithSummary marks the same SMS as verified for each of the given end users phone numbers
+// in the same way as MarkSMSAsVerifiedForNumbers, and also returns a summary of the operation
+func (partner Partner) MarkSMSAsVerifiedForNumbersWithSummary(ctx context.Context, phoneNumbers []string, agent *Agent, smsMessage string) (map[string]VerificationResult, *BatchSummary, error) {
+	start := time.Now()
+	partner, counter := partner.withAPICallCounter()
+
+	results, err := partner.MarkSMSAsVerifiedForNumbers(ctx, phoneNumbers, agent, smsMessage)
+
+	summary := &BatchSummary{
+		APICalls: counter.count(),
+		Duration: time.Since(start),
+	}
+
+	for _, result := range results {
+		if result.IsVerified() {
+			summary.Verified++
+		} else if result.Status == NotSupported {
+			summary.NotSupported++
+		}
+	}
+
+	if numberErrors, ok := err.(NumberErrors); ok {
+		summary.Failed = len(numberErrors)
+	}
+
+	return results, summary, err
+}
+
+// BatchMarkSMSAsVerifiedWithSummary marks a set of SMS messages as verified in the same way as
+// BatchMarkSMSAsVerified, and also returns a summary of the operation
+func (partner Partner) BatchMarkSMSAsVerifiedWithSummary(ctx context.Context, smsMessages map[string]string, agent *Agent) (map[string]bool, map[string]error, *BatchSummary, error) {
+	start := time.Now()
+	partner, counter := partner.withAPICallCounter()
+
+	verified, errs, err := partner.BatchMarkSMSAsVerified(ctx, smsMessages, agent)
+
+	summary := &BatchSummary{
+		APICalls: counter.count(),
+		Duration: time.Since(start),
+	}
+
+	if err != nil {
+		summary.Failed = len(smsMessages)
+		return verified, errs, summary, err
+	}
+
+	summary.Failed = len(errs)
+
+	for phoneNumber, isVerified := range verified {
+		if isVerified {
+			summary.Verified++
+		} else if errs[phoneNumber] == nil {
+			summary.NotSupported++
+		}
+	}
+
+	return verified, errs, summary, err
+}
+
+// apiCallCounter is an Observer which counts calls to Google before passing every observation on to the Partner's own
+// Observer
+type apiCallCounter struct {
+	next  Observer
+	calls int64
+}
+
+// withAPICallCounter returns a copy of the Partner which counts its calls to Google, along with the counter
+func (partner Partner) withAPICallCounter() (Partner, *apiCallCounter) {
+	counter := &apiCallCounter{
+		next: partner.Observer,
+	}
+
+	partner.Observer = counter
+
+	return partner, counter
+}
+
+func (counter *apiCallCounter) count() int {
+	return int(atomic.LoadInt64(&counter.calls))
+}
+
+func (counter *apiCallCounter) ObserveVerification(result *VerificationResult, duration time.Duration, err error) {
+	if counter.next != nil {
+		counter.next.ObserveVerification(result, duration, err)
+	}
+}
+
+func (counter *apiCallCounter) ObserveAPICall(endpoint string, statusCode int, duration time.Duration, err error) {
+	atomic.AddInt64(&counter.calls, 1)
+
+	if counter.next != nil {
+		counter.next.ObserveAPICall(endpoint, statusCode, duration, err)
+	}
+}
@@ -0,0 +1,136 @@
+package verifiedsms
+
+import (
+	"context"
+	"github.com/monzo/terrors"
+	"sync"
+)
+
+// DefaultVerificationConcurrency is the number of phone numbers verified in parallel by MarkSMSAsVerifiedForNumbers
+// unless the Partner's VerificationConcurrency is set
+const DefaultVerificationConcurrency = 10
+
+// MarkSMSAsVerifiedForNumbers marks the same SMS as verified for each of the given end users phone numbers, verifying
+// up to the Partner's VerificationConcurrency numbers at once
+// Returns the result for every phone number which was verified without error. If any phone numbers couldn't be
+// verified a NumberErrors is returned describing why, cancelling the context stops any further numbers from being
+// verified and aborts those in flight
+func (partner Partner) MarkSMSAsVerifiedForNumbers(ctx context.Context, phoneNumbers []string, agent *Agent, smsMessage string) (map[string]VerificationResult, error) {
+	concurrency := partner.VerificationConcurrency
+	if concurrency < 1 {
+		concurrency = DefaultVerificationConcurrency
+	}
+
+	results := make(map[string]VerificationResult, len(phoneNumbers))
+	errs := NumberErrors{}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	semaphore := make(chan struct{}, concurrency)
+
+	for _, phoneNumber := range phoneNumbers {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+			// Numbers still in flight write their errors under mu too
+			mu.Lock()
+			errs[phoneNumber] = terrors.Propagate(ctx.Err())
+			mu.Unlock()
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(phoneNumber string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			result, err := partner.MarkSMSAsVerifiedResult(ctx, phoneNumber, agent, smsMessage)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				errs[phoneNumber] = err
+				return
+			}
+
+			results[phoneNumber] = *result
+		}(phoneNumber)
+	}
+
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return results, errs
+	}
+
+	return results, nil
+}
+
+// NumberResult is the outcome of verifying an SMS for a single phone number in MarkSMSAsVerifiedStream
+type NumberResult struct {
+	// PhoneNumber is the end users phone number
+	PhoneNumber string
+
+	// Result describes the verification, it is nil if Err is set
+	Result *VerificationResult
+
+	// Err is why the SMS couldn't be verified for the phone number
+	Err error
+}
+
+// MarkSMSAsVerifiedStream marks the same SMS as verified for each end users phone number received from phoneNumbers,
+// verifying up to the Partner's VerificationConcurrency numbers at once, and sends each result as soon as it's ready so
+// lists too large to hold in memory can be verified
+// Phone numbers are only received as fast as they're verified. The returned channel is closed once phoneNumbers is
+// closed and every number received has been verified, or once the context is done, in which case numbers being
+// verified may not have a result sent
+func (partner Partner) MarkSMSAsVerifiedStream(ctx context.Context, phoneNumbers <-chan string, agent *Agent, smsMessage string) <-chan NumberResult {
+	concurrency := partner.VerificationConcurrency
+	if concurrency < 1 {
+		concurrency = DefaultVerificationConcurrency
+	}
+
+	results := make(chan NumberResult)
+
+	var wg sync.WaitGroup
+
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				var phoneNumber string
+				var ok bool
+
+				select {
+				case phoneNumber, ok = <-phoneNumbers:
+					if !ok {
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+
+				result, err := partner.MarkSMSAsVerifiedResult(ctx, phoneNumber, agent, smsMessage)
+
+				select {
+				case results <- NumberResult{PhoneNumber: phoneNumber, Result: result, Err: err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
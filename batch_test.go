@@ -0,0 +1,54 @@
+package verifiedsms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMarkSMSAsVerifiedForNumbersCancelledMidFanOut(t *testing.T) {
+	agent := newTestAgent(t)
+
+	var phoneNumbers []string
+	publicKeys := map[string][]string{}
+	for i := 0; i < 50; i++ {
+		phoneNumber := fmt.Sprintf("+4477009%05d", i)
+		phoneNumbers = append(phoneNumbers, phoneNumber)
+		publicKeys[phoneNumber] = []string{newTestUserKey(t)}
+	}
+
+	google := newFakeGoogle(publicKeys)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	partner := newTestPartner(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Cancel while requests are in flight, so workers and the loop handing out numbers both record errors
+		if google.requestCount(apiSubmitHashesPath) >= 5 {
+			cancel()
+		}
+		time.Sleep(time.Millisecond)
+
+		google.ServeHTTP(w, r)
+	}))
+	partner.VerificationConcurrency = 2
+
+	results, err := partner.MarkSMSAsVerifiedForNumbers(ctx, phoneNumbers, agent, "Your code is 1234")
+
+	numberErrors := NumberErrors{}
+	if !errors.As(err, &numberErrors) {
+		t.Fatalf("expected NumberErrors, got %v", err)
+	}
+
+	if len(results)+len(numberErrors) != len(phoneNumbers) {
+		t.Errorf("expected a result or error for all %d numbers, got %d results and %d errors", len(phoneNumbers),
+			len(results), len(numberErrors))
+	}
+
+	if len(numberErrors) == 0 {
+		t.Errorf("expected numbers after the cancellation to fail")
+	}
+}
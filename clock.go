@@ -0,0 +1,21 @@
+package verifiedsms
+
+import "time"
+
+// clock tells the time and waits for it to pass, so tests can control time when checking cache expiry and retry
+// backoff rather than really sleeping
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the clock used outside of tests, backed by the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,57 @@
+package verifiedsms
+
+import (
+	"sync"
+	"time"
+)
+
+// fakeClock is a clock for tests where time only passes when something waits for it, so waits return straight away
+// and every delay asked for is recorded
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	delays []time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func (clock *fakeClock) Now() time.Time {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+
+	return clock.now
+}
+
+// After moves the clock forward by d and returns a channel which has already fired
+func (clock *fakeClock) After(d time.Duration) <-chan time.Time {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+
+	clock.delays = append(clock.delays, d)
+	clock.now = clock.now.Add(d)
+
+	fired := make(chan time.Time, 1)
+	fired <- clock.now
+
+	return fired
+}
+
+// Advance moves the clock forward by d
+func (clock *fakeClock) Advance(d time.Duration) {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+
+	clock.now = clock.now.Add(d)
+}
+
+// Delays returns every delay waited for with After
+func (clock *fakeClock) Delays() []time.Duration {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+
+	return append([]time.Duration{}, clock.delays...)
+}
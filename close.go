@@ -0,0 +1,20 @@
+package verifiedsms
+
+// Close releases the idle connections of the Partner's HTTPClient and any public keys in its PublicKeyCache
+// It should be called once at shutdown, and the Partner shouldn't be used afterwards, requests made by it fail with an
+// error matching ErrPartnerClosed. Copies of the Partner made before it was closed aren't affected
+// The default client and the token cache are shared by every Partner in the process, so they're left alone, the
+// connections in the default client's pool are closed once they've been idle for long enough and cached tokens expire
+func (partner *Partner) Close() error {
+	partner.closed = true
+
+	if partner.HTTPClient != nil {
+		partner.HTTPClient.CloseIdleConnections()
+	}
+
+	if partner.PublicKeyCache != nil {
+		partner.PublicKeyCache.Clear()
+	}
+
+	return nil
+}
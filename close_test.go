@@ -0,0 +1,59 @@
+package verifiedsms
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// idleClosingTransport counts how many times its idle connections were closed
+type idleClosingTransport struct {
+	http.RoundTripper
+	closed int
+}
+
+func (transport *idleClosingTransport) CloseIdleConnections() {
+	transport.closed++
+}
+
+func TestCloseReleasesThePartnersOwnConnections(t *testing.T) {
+	partner := newTestPartner(t, newFakeGoogle(nil))
+
+	transport := &idleClosingTransport{RoundTripper: http.DefaultTransport}
+	partner.HTTPClient = &http.Client{Transport: transport}
+	partner.PublicKeyCache = NewPublicKeyCache(time.Minute, 0)
+	partner.PublicKeyCache.Set("+447700900001", []string{"key"})
+
+	if err := partner.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if transport.closed != 1 {
+		t.Errorf("expected the Partner's idle connections to be closed once, got %d", transport.closed)
+	}
+
+	if _, ok := partner.PublicKeyCache.Get("+447700900001"); ok {
+		t.Errorf("expected the public key cache to be cleared")
+	}
+
+	_, err := partner.GetPhoneNumberPublicKeys(context.Background(), "+447700900001")
+	if !errors.Is(err, ErrPartnerClosed) {
+		t.Errorf("expected requests after Close to fail with ErrPartnerClosed, got %v", err)
+	}
+}
+
+func TestCloseDoesntAffectOtherPartners(t *testing.T) {
+	google := newFakeGoogle(nil)
+	partner := newTestPartner(t, google)
+	other := partner
+
+	if err := partner.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := other.GetPhoneNumberPublicKeys(context.Background(), "+447700900001"); err != nil {
+		t.Errorf("expected a Partner which wasn't closed to keep working, got %v", err)
+	}
+}
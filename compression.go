@@ -0,0 +1,91 @@
+package verifiedsms
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/monzo/terrors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// gzipContentEncoding is the Content-Encoding of gzipped bodies
+const gzipContentEncoding = "gzip"
+
+// encodeRequestBody gzips requestBody if the Partner compresses requests and it's at least the compression threshold
+// Returns the body to send and its Content-Encoding, which is empty if it wasn't compressed
+func (partner Partner) encodeRequestBody(requestBody []byte) ([]byte, string, error) {
+	if !partner.CompressRequests || len(requestBody) < partner.compressionThreshold() {
+		return requestBody, "", nil
+	}
+
+	var compressed bytes.Buffer
+
+	writer := gzip.NewWriter(&compressed)
+
+	_, err := writer.Write(requestBody)
+	if err != nil {
+		return nil, "", terrors.Augment(err, "failed to gzip request body", nil)
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, "", terrors.Augment(err, "failed to gzip request body", nil)
+	}
+
+	return compressed.Bytes(), gzipContentEncoding, nil
+}
+
+// compressionThreshold returns the smallest request body the Partner gzips
+func (partner Partner) compressionThreshold() int {
+	if partner.CompressionThreshold <= 0 {
+		return DefaultCompressionThreshold
+	}
+
+	return partner.CompressionThreshold
+}
+
+// decodeResponseBody replaces the body of httpResponse with its decompressed contents if Google compressed it
+// Go's transport only decompresses responses itself when it added the Accept-Encoding header, which it doesn't when a
+// custom transport is used or, as sendRequest does, the request asks for gzip explicitly, so it's always done here
+func decodeResponseBody(httpResponse *http.Response) error {
+	encoding := strings.ToLower(strings.TrimSpace(httpResponse.Header.Get("Content-Encoding")))
+
+	switch encoding {
+	case "", "identity":
+		return nil
+	case gzipContentEncoding:
+	default:
+		return terrors.BadResponse(terrors.ErrBadResponse, "response from Google has an unsupported Content-Encoding", map[string]string{
+			"content_encoding": encoding,
+		})
+	}
+
+	reader, err := gzip.NewReader(httpResponse.Body)
+	if err == io.EOF {
+		// An empty body has nothing to decompress
+		return nil
+	}
+	if err != nil {
+		return terrors.BadResponse(terrors.ErrBadResponse, "response from Google is not valid gzip", map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	httpResponse.Body = gzipBody{
+		Reader: reader,
+		Closer: httpResponse.Body,
+	}
+	httpResponse.Header.Del("Content-Encoding")
+	httpResponse.Header.Del("Content-Length")
+	httpResponse.ContentLength = -1
+	httpResponse.Uncompressed = true
+
+	return nil
+}
+
+// gzipBody reads a decompressed response body, closing the underlying body
+type gzipBody struct {
+	io.Reader
+	io.Closer
+}
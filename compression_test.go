@@ -0,0 +1,132 @@
+package verifiedsms
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/monzo/terrors"
+)
+
+func TestCompressRequestsGzipsTheBody(t *testing.T) {
+	phoneNumbers := make([]string, 100)
+	for i := range phoneNumbers {
+		phoneNumbers[i] = fmt.Sprintf("+447700900%03d", i)
+	}
+
+	var received []string
+	partner := newTestPartner(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("expected a gzipped body, got Content-Encoding %q", r.Header.Get("Content-Encoding"))
+		}
+
+		reader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("expected the body to be valid gzip: %v", err)
+			return
+		}
+
+		request := struct {
+			PhoneNumbers []string `json:"phoneNumbers"`
+		}{}
+		if err := json.NewDecoder(reader).Decode(&request); err != nil {
+			t.Errorf("expected the body to decompress to JSON: %v", err)
+			return
+		}
+
+		received = request.PhoneNumbers
+		_, _ = w.Write([]byte("{}"))
+	}))
+	partner.CompressRequests = true
+
+	if _, err := partner.GetPhoneNumbersPublicKeys(context.Background(), phoneNumbers); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(received, phoneNumbers) {
+		t.Errorf("expected the decompressed body to have every phone number, got %v", received)
+	}
+}
+
+func TestCompressRequestsLeavesSmallBodiesAlone(t *testing.T) {
+	testCases := map[string]Partner{
+		"below the threshold": {CompressRequests: true},
+		"disabled":            {CompressionThreshold: 1},
+	}
+
+	for name, options := range testCases {
+		t.Run(name, func(t *testing.T) {
+			partner := newTestPartner(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if encoding := r.Header.Get("Content-Encoding"); encoding != "" {
+					t.Errorf("expected an uncompressed body, got Content-Encoding %q", encoding)
+				}
+
+				body, _ := io.ReadAll(r.Body)
+				if !json.Valid(body) {
+					t.Errorf("expected a JSON body, got %q", body)
+				}
+
+				_, _ = w.Write([]byte("{}"))
+			}))
+			partner.CompressRequests = options.CompressRequests
+			partner.CompressionThreshold = options.CompressionThreshold
+
+			if _, err := partner.GetPhoneNumbersPublicKeys(context.Background(), []string{"+447700900001"}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestGzippedResponsesAreDecoded(t *testing.T) {
+	partner := newTestPartner(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			t.Errorf("expected gzip to be accepted, got %q", r.Header.Get("Accept-Encoding"))
+		}
+
+		w.Header().Set("Content-Encoding", "gzip")
+
+		writer := gzip.NewWriter(w)
+		_, _ = writer.Write([]byte(`{"userKeys":[{"phoneNumber":"+447700900001","publicKey":"key"}]}`))
+		_ = writer.Close()
+	}))
+
+	publicKeys, err := partner.GetPhoneNumberPublicKeys(context.Background(), "+447700900001")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(publicKeys, []string{"key"}) {
+		t.Errorf("expected the decompressed public key, got %v", publicKeys)
+	}
+}
+
+func TestGzippedErrorResponsesAreDecoded(t *testing.T) {
+	partner := newTestPartner(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(http.StatusBadRequest)
+
+		writer := gzip.NewWriter(w)
+		_, _ = writer.Write([]byte(`{"error":{"code":400,"message":"phone number is invalid","status":"INVALID_ARGUMENT"}}`))
+		_ = writer.Close()
+	}))
+
+	_, err := partner.GetPhoneNumberPublicKeys(context.Background(), "+447700900001")
+	if !terrors.Is(err, terrors.ErrBadRequest) {
+		t.Fatalf("expected a bad request error, got %v", err)
+	}
+
+	params := err.(*terrors.Error).Params
+	if params["google_status"] != "INVALID_ARGUMENT" {
+		t.Errorf("expected the decompressed status, got %q", params["google_status"])
+	}
+
+	if params["google_message"] != "phone number is invalid" {
+		t.Errorf("expected the decompressed message, got %q", params["google_message"])
+	}
+}
@@ -0,0 +1,25 @@
+package verifiedsms
+
+import (
+	"context"
+)
+
+// CorrelationIDHeader is the header the correlation ID from WithCorrelationID is sent to Google in, so requests can be
+// matched up with Google's logs when debugging with them
+const CorrelationIDHeader = "X-Correlation-Id"
+
+type correlationIDKey struct{}
+
+// WithCorrelationID returns a context which tags verifications and requests to Google with the given correlation ID,
+// e.g. a trace ID. It is sent to Google in the CorrelationIDHeader, added to logs and spans, and returned in the
+// VerificationResult
+// Observers aren't given it, as an ID unique to each request isn't suitable as a metric label
+func WithCorrelationID(ctx context.Context, correlationID string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, correlationID)
+}
+
+// CorrelationIDFromContext returns the correlation ID set with WithCorrelationID, or an empty string if there isn't one
+func CorrelationIDFromContext(ctx context.Context) string {
+	correlationID, _ := ctx.Value(correlationIDKey{}).(string)
+	return correlationID
+}
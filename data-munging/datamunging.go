@@ -1,22 +1,301 @@
 package data_munging
 
 import (
+	"golang.org/x/text/unicode/norm"
+	"regexp"
 	"strings"
+	"unicode"
 )
 
 // This file is responsible for creating the different types of SMS message that could end up being delivered to the
 // users device based on data munging by phone carriers. This will never be exhaustive, but is intended to capture as
 // many devices as possible.
 
+// Transformer produces variants of an SMS message that a carrier might deliver instead of the original
+type Transformer interface {
+	// Apply returns the variants of smsMessage, it doesn't need to include smsMessage itself or avoid duplicates
+	Apply(smsMessage string) []string
+}
+
+// TransformerFunc adapts an ordinary function into a Transformer
+type TransformerFunc func(smsMessage string) []string
+
+// Apply calls f(smsMessage)
+func (f TransformerFunc) Apply(smsMessage string) []string {
+	return f(smsMessage)
+}
+
+var (
+	// TrimSpaceTransformer produces the message with leading and trailing whitespace removed
+	TrimSpaceTransformer Transformer = TransformerFunc(func(smsMessage string) []string {
+		return []string{strings.TrimSpace(smsMessage)}
+	})
+
+	// UnicodeNormalizationTransformer produces the NFC and NFD normalised forms of the message, as devices and
+	// carriers don't agree on whether accented characters are sent composed or decomposed
+	UnicodeNormalizationTransformer Transformer = TransformerFunc(func(smsMessage string) []string {
+		return []string{norm.NFC.String(smsMessage), norm.NFD.String(smsMessage)}
+	})
+
+	// LineEndingTransformer produces the message with every line ending as LF and with every line ending as CRLF, as
+	// carriers rewrite line endings in multi-line messages in both directions
+	LineEndingTransformer Transformer = TransformerFunc(func(smsMessage string) []string {
+		lfMessage := strings.ReplaceAll(smsMessage, "\r\n", "\n")
+		crlfMessage := strings.ReplaceAll(lfMessage, "\n", "\r\n")
+		return []string{lfMessage, crlfMessage}
+	})
+
+	// TrailingPunctuationTransformer produces the message with trailing whitespace stripped and with a trailing period
+	// removed, as some devices do this after a final word or URL. A trailing period is only added after a URL ending
+	// the message, as adding one after every word would double the hashes for almost every message
+	TrailingPunctuationTransformer Transformer = TransformerFunc(func(smsMessage string) []string {
+		trimmedMessage := strings.TrimRightFunc(smsMessage, unicode.IsSpace)
+
+		variants := []string{trimmedMessage}
+
+		if strings.HasSuffix(trimmedMessage, ".") {
+			variants = append(variants, strings.TrimSuffix(trimmedMessage, "."))
+		} else if endsWithURL(trimmedMessage) {
+			variants = append(variants, trimmedMessage+".")
+		}
+
+		return variants
+	})
+
+	// NonBreakingSpaceTransformer produces the message with every non-breaking space (U+00A0) replaced by a regular
+	// space, and with regular spaces before currency symbols replaced by non-breaking spaces, as carriers insert and
+	// strip them around amounts like "£5.00"
+	NonBreakingSpaceTransformer Transformer = TransformerFunc(func(smsMessage string) []string {
+		return []string{
+			strings.ReplaceAll(smsMessage, nonBreakingSpace, " "),
+			addNonBreakingSpacesBeforeCurrencySymbols(smsMessage),
+		}
+	})
+
+	// WhitespaceCollapsingTransformer produces the message with every run of spaces and tabs between words collapsed
+	// into a single space, as some gateways do this. Line breaks are left alone so multi-line messages keep their shape
+	WhitespaceCollapsingTransformer Transformer = TransformerFunc(func(smsMessage string) []string {
+		return []string{collapseInteriorWhitespace(smsMessage)}
+	})
+
+	// URLTrailingSlashTransformer produces the message with the trailing slash removed from every URL in it, and with
+	// a trailing slash added to every URL without one, as some carriers rewrite links. Only URLs are changed, and
+	// punctuation ending the sentence after a URL isn't treated as part of it
+	URLTrailingSlashTransformer Transformer = TransformerFunc(func(smsMessage string) []string {
+		return []string{
+			replaceURLs(smsMessage, func(url string) string {
+				return strings.TrimSuffix(url, "/")
+			}),
+			replaceURLs(smsMessage, func(url string) string {
+				if strings.HasSuffix(url, "/") || strings.ContainsAny(url, "?#") {
+					return url
+				}
+				return url + "/"
+			}),
+		}
+	})
+
+	// EmojiVariationSelectorTransformer produces the message with the emoji variation selector (U+FE0F) removed from
+	// every single emoji it follows, and with it added after every symbol which can be shown as an emoji and doesn't
+	// have a variation selector, as devices and carriers add and strip it, e.g. turning "✅" into "✅\ufe0f"
+	// It is deliberately conservative: emoji joined into sequences, with skin tone modifiers or in keycaps are left alone
+	EmojiVariationSelectorTransformer Transformer = TransformerFunc(func(smsMessage string) []string {
+		if !containsEmoji(smsMessage) {
+			return nil
+		}
+
+		return []string{
+			stripEmojiVariationSelectors(smsMessage),
+			addEmojiVariationSelectors(smsMessage),
+		}
+	})
+)
+
+const (
+	emojiVariationSelector = '\ufe0f'
+	textVariationSelector  = '\ufe0e'
+	zeroWidthJoiner        = '\u200d'
+	combiningKeycap        = '\u20e3'
+)
+
+// containsEmoji reports whether smsMessage contains any emoji
+func containsEmoji(smsMessage string) bool {
+	return strings.IndexFunc(smsMessage, isEmojiSymbol) != -1
+}
+
+// isEmojiSymbol reports whether r is an emoji on its own, so it can take a variation selector
+func isEmojiSymbol(r rune) bool {
+	return unicode.Is(emojiSymbols, r)
+}
+
+// isEmojiModifier reports whether r continues an emoji sequence, so the emoji before it shouldn't be changed
+func isEmojiModifier(r rune) bool {
+	return r == emojiVariationSelector || r == textVariationSelector || r == zeroWidthJoiner || r == combiningKeycap ||
+		(r >= 0x1f3fb && r <= 0x1f3ff)
+}
+
+// stripEmojiVariationSelectors removes every emoji variation selector which directly follows a single emoji
+func stripEmojiVariationSelectors(smsMessage string) string {
+	runes := []rune(smsMessage)
+	stripped := make([]rune, 0, len(runes))
+
+	for i, r := range runes {
+		if r == emojiVariationSelector && i > 0 && isEmojiSymbol(runes[i-1]) &&
+			(i == len(runes)-1 || !isEmojiModifier(runes[i+1])) {
+			continue
+		}
+
+		stripped = append(stripped, r)
+	}
+
+	return string(stripped)
+}
+
+// addEmojiVariationSelectors adds an emoji variation selector after every single emoji which doesn't already have a
+// variation selector or modifier
+func addEmojiVariationSelectors(smsMessage string) string {
+	runes := []rune(smsMessage)
+	added := make([]rune, 0, len(runes))
+
+	for i, r := range runes {
+		added = append(added, r)
+
+		if isEmojiSymbol(r) && (i == len(runes)-1 || !isEmojiModifier(runes[i+1])) {
+			added = append(added, emojiVariationSelector)
+		}
+	}
+
+	return string(added)
+}
+
+// urlPattern matches http and https URLs, ending at the first whitespace
+var urlPattern = regexp.MustCompile(`https?://[^\s]+`)
+
+// endsWithURL reports whether the last word of smsMessage is a URL
+func endsWithURL(smsMessage string) bool {
+	lastWord := smsMessage[strings.LastIndexFunc(smsMessage, unicode.IsSpace)+1:]
+	return lastWord != "" && urlPattern.FindString(lastWord) == lastWord
+}
+
+// urlTrailingPunctuation is punctuation which is more likely to end the sentence than the URL before it
+const urlTrailingPunctuation = ".,!?;:)'\""
+
+// replaceURLs replaces every URL in smsMessage with replace(url)
+func replaceURLs(smsMessage string, replace func(url string) string) string {
+	return urlPattern.ReplaceAllStringFunc(smsMessage, func(match string) string {
+		url := strings.TrimRight(match, urlTrailingPunctuation)
+		return replace(url) + match[len(url):]
+	})
+}
+
+const nonBreakingSpace = "\u00a0"
+
+// addNonBreakingSpacesBeforeCurrencySymbols replaces every regular space immediately before a currency symbol with a
+// non-breaking space
+func addNonBreakingSpacesBeforeCurrencySymbols(smsMessage string) string {
+	runes := []rune(smsMessage)
+
+	for i := 0; i < len(runes)-1; i++ {
+		if runes[i] == ' ' && unicode.Is(unicode.Sc, runes[i+1]) {
+			runes[i] = '\u00a0'
+		}
+	}
+
+	return string(runes)
+}
+
+// collapseInteriorWhitespace replaces every run of spaces and tabs with a single space, unless the run is at the start
+// or end of a line
+func collapseInteriorWhitespace(smsMessage string) string {
+	var builder strings.Builder
+	builder.Grow(len(smsMessage))
+
+	runes := []rune(smsMessage)
+
+	for i := 0; i < len(runes); i++ {
+		if !isHorizontalSpace(runes[i]) {
+			builder.WriteRune(runes[i])
+			continue
+		}
+
+		end := i
+		for end < len(runes) && isHorizontalSpace(runes[end]) {
+			end++
+		}
+
+		atLineStart := i == 0 || isLineBreak(runes[i-1])
+		atLineEnd := end == len(runes) || isLineBreak(runes[end])
+
+		if atLineStart || atLineEnd {
+			builder.WriteString(string(runes[i:end]))
+		} else {
+			builder.WriteRune(' ')
+		}
+
+		i = end - 1
+	}
+
+	return builder.String()
+}
+
+func isHorizontalSpace(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+func isLineBreak(r rune) bool {
+	return r == '\n' || r == '\r'
+}
+
+// DefaultTransformers returns the built-in transformers used by GetAllIterationsOfSMSMessage
+func DefaultTransformers() []Transformer {
+	return []Transformer{
+		TrimSpaceTransformer,
+		UnicodeNormalizationTransformer,
+		LineEndingTransformer,
+		TrailingPunctuationTransformer,
+		NonBreakingSpaceTransformer,
+		WhitespaceCollapsingTransformer,
+		URLTrailingSlashTransformer,
+		EmojiVariationSelectorTransformer,
+	}
+}
+
+// GetAllIterationsOfSMSMessage returns every distinct variant of smsMessage that could be delivered to the users
+// device using the built-in transformers, the original message is always first
 func GetAllIterationsOfSMSMessage(smsMessage string) []string {
+	return GetIterationsOfSMSMessage(smsMessage, DefaultTransformers())
+}
+
+// GetIterationsOfSMSMessage returns every distinct variant of smsMessage produced by transformers, in order
+// Each transformer is only applied to the original message rather than to the variants of the transformers before it,
+// so the number of variants grows with the number of transformers rather than multiplying, as every variant costs a
+// hash for each of the users' keys
+// The original message is always first
+func GetIterationsOfSMSMessage(smsMessage string, transformers []Transformer) []string {
 	iterations := []string{
 		smsMessage,
 	}
 
-	trimmedMessage := strings.TrimSpace(smsMessage)
-	if trimmedMessage != smsMessage {
-		iterations = append(iterations, smsMessage)
+	for _, transformer := range transformers {
+		iterations = append(iterations, transformer.Apply(smsMessage)...)
+	}
+
+	return dedupe(iterations)
+}
+
+// dedupe removes repeated iterations while preserving the order in which they first appear
+func dedupe(iterations []string) []string {
+	seen := make(map[string]bool, len(iterations))
+	deduped := make([]string, 0, len(iterations))
+
+	for _, iteration := range iterations {
+		if seen[iteration] {
+			continue
+		}
+
+		seen[iteration] = true
+		deduped = append(deduped, iteration)
 	}
 
-	return iterations
+	return deduped
 }
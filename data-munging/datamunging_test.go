@@ -0,0 +1,503 @@
+package data_munging
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetAllIterationsOfSMSMessage(t *testing.T) {
+	testCases := []struct {
+		name     string
+		message  string
+		expected []string
+	}{
+		{
+			name:    "plain message",
+			message: "hello",
+			expected: []string{
+				"hello",
+			},
+		},
+		{
+			name:    "card alert",
+			message: "Café £5.00 spent at Pret ✅\nSee https://monzo.me/x\nThanks",
+			expected: []string{
+				"Café £5.00 spent at Pret ✅\nSee https://monzo.me/x\nThanks",
+				"Cafe\u0301 £5.00 spent at Pret ✅\nSee https://monzo.me/x\nThanks",
+				"Café £5.00 spent at Pret ✅\r\nSee https://monzo.me/x\r\nThanks",
+				"Café\u00a0£5.00 spent at Pret ✅\nSee https://monzo.me/x\nThanks",
+				"Café £5.00 spent at Pret ✅\nSee https://monzo.me/x/\nThanks",
+				"Café £5.00 spent at Pret ✅\ufe0f\nSee https://monzo.me/x\nThanks",
+			},
+		},
+		{
+			name:    "every transformer applies",
+			message: " Café  £5 ✅ see https://monzo.me/x/\r\nThanks ",
+			expected: []string{
+				" Café  £5 ✅ see https://monzo.me/x/\r\nThanks ",
+				"Café  £5 ✅ see https://monzo.me/x/\r\nThanks",
+				" Cafe\u0301  £5 ✅ see https://monzo.me/x/\r\nThanks ",
+				" Café  £5 ✅ see https://monzo.me/x/\nThanks ",
+				" Café  £5 ✅ see https://monzo.me/x/\r\nThanks",
+				" Café \u00a0£5 ✅ see https://monzo.me/x/\r\nThanks ",
+				" Café £5 ✅ see https://monzo.me/x/\r\nThanks ",
+				" Café  £5 ✅ see https://monzo.me/x\r\nThanks ",
+				" Café  £5 ✅\ufe0f see https://monzo.me/x/\r\nThanks ",
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			iterations := GetAllIterationsOfSMSMessage(testCase.message)
+			if !reflect.DeepEqual(iterations, testCase.expected) {
+				t.Errorf("expected %q, got %q", testCase.expected, iterations)
+			}
+		})
+	}
+}
+
+func TestTrailingPunctuationTransformer(t *testing.T) {
+	testCases := []struct {
+		name     string
+		message  string
+		expected []string
+	}{
+		{
+			name:     "ends in a link",
+			message:  "Track your card at https://monzo.me/x",
+			expected: []string{"Track your card at https://monzo.me/x", "Track your card at https://monzo.me/x."},
+		},
+		{
+			name:     "ends in a link and a space",
+			message:  "Track your card at https://monzo.me/x ",
+			expected: []string{"Track your card at https://monzo.me/x", "Track your card at https://monzo.me/x."},
+		},
+		{
+			name:     "ends in a period",
+			message:  "Thanks.",
+			expected: []string{"Thanks.", "Thanks"},
+		},
+		{
+			name:     "ends in a word",
+			message:  "Your code is 1234",
+			expected: []string{"Your code is 1234"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			variants := TrailingPunctuationTransformer.Apply(testCase.message)
+			if !reflect.DeepEqual(variants, testCase.expected) {
+				t.Errorf("expected %q, got %q", testCase.expected, variants)
+			}
+		})
+	}
+}
+
+func TestGetIterationsOfSMSMessageAppliesTransformersToTheOriginal(t *testing.T) {
+	appendA := TransformerFunc(func(smsMessage string) []string {
+		return []string{smsMessage + "a"}
+	})
+	appendB := TransformerFunc(func(smsMessage string) []string {
+		return []string{smsMessage + "b"}
+	})
+
+	iterations := GetIterationsOfSMSMessage("x", []Transformer{appendA, appendB, appendA})
+
+	expected := []string{"x", "xa", "xb"}
+	if !reflect.DeepEqual(iterations, expected) {
+		t.Errorf("expected %q, got %q", expected, iterations)
+	}
+}
+
+func TestGetAllIterationsOfSMSMessageTrimsWhitespace(t *testing.T) {
+	iterations := GetAllIterationsOfSMSMessage("  Your code is 1234\n")
+
+	expected := []string{"  Your code is 1234\n", "Your code is 1234", "  Your code is 1234\r\n", "  Your code is 1234"}
+	if !reflect.DeepEqual(iterations, expected) {
+		t.Errorf("expected %q, got %q", expected, iterations)
+	}
+}
+
+func TestTrimSpaceTransformer(t *testing.T) {
+	variants := TrimSpaceTransformer.Apply(" \tYour code is 1234 \n")
+
+	expected := []string{"Your code is 1234"}
+	if !reflect.DeepEqual(variants, expected) {
+		t.Errorf("expected %q, got %q", expected, variants)
+	}
+}
+
+func TestGetAllIterationsOfSMSMessageDedupesTrimmedMessages(t *testing.T) {
+	iterations := GetAllIterationsOfSMSMessage("Your code is 1234")
+
+	expected := []string{"Your code is 1234"}
+	if !reflect.DeepEqual(iterations, expected) {
+		t.Errorf("expected a message without surrounding whitespace to have one iteration, got %q", iterations)
+	}
+}
+
+func TestUnicodeNormalizationTransformer(t *testing.T) {
+	testCases := []struct {
+		name     string
+		message  string
+		expected []string
+	}{
+		{
+			name:     "composed accents",
+			message:  "Café crème",
+			expected: []string{"Café crème", "Cafe\u0301 cre\u0300me"},
+		},
+		{
+			name:     "decomposed accents",
+			message:  "Cafe\u0301 cre\u0300me",
+			expected: []string{"Café crème", "Cafe\u0301 cre\u0300me"},
+		},
+		{
+			// NFC and NFD are canonical forms, which leave full-width characters alone
+			name:     "full-width digits",
+			message:  "Your code is １２３４",
+			expected: []string{"Your code is １２３４", "Your code is １２３４"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			variants := UnicodeNormalizationTransformer.Apply(testCase.message)
+			if !reflect.DeepEqual(variants, testCase.expected) {
+				t.Errorf("expected %q, got %q", testCase.expected, variants)
+			}
+		})
+	}
+}
+
+func TestGetAllIterationsOfSMSMessageOnlyAddsNormalizationFormsWhichDiffer(t *testing.T) {
+	testCases := []struct {
+		name     string
+		message  string
+		expected []string
+	}{
+		{
+			name:     "decomposed accent",
+			message:  "Cafe\u0301",
+			expected: []string{"Cafe\u0301", "Café"},
+		},
+		{
+			name:     "full-width digits",
+			message:  "Code １２３４",
+			expected: []string{"Code １２３４"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			iterations := GetAllIterationsOfSMSMessage(testCase.message)
+			if !reflect.DeepEqual(iterations, testCase.expected) {
+				t.Errorf("expected %q, got %q", testCase.expected, iterations)
+			}
+		})
+	}
+}
+
+func TestLineEndingTransformer(t *testing.T) {
+	testCases := []struct {
+		name     string
+		message  string
+		expected []string
+	}{
+		{
+			name:     "LF",
+			message:  "Your code is 1234\nDon't share it",
+			expected: []string{"Your code is 1234\nDon't share it", "Your code is 1234\r\nDon't share it"},
+		},
+		{
+			name:     "CRLF",
+			message:  "Your code is 1234\r\nDon't share it",
+			expected: []string{"Your code is 1234\nDon't share it", "Your code is 1234\r\nDon't share it"},
+		},
+		{
+			name:     "mixed",
+			message:  "Your code is 1234\r\nDon't share it\nThanks",
+			expected: []string{"Your code is 1234\nDon't share it\nThanks", "Your code is 1234\r\nDon't share it\r\nThanks"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			variants := LineEndingTransformer.Apply(testCase.message)
+			if !reflect.DeepEqual(variants, testCase.expected) {
+				t.Errorf("expected %q, got %q", testCase.expected, variants)
+			}
+		})
+	}
+}
+
+func TestGetAllIterationsOfSMSMessageLineEndings(t *testing.T) {
+	testCases := []struct {
+		name     string
+		message  string
+		expected []string
+	}{
+		{
+			name:     "two lines",
+			message:  "Your code is 1234\r\nDon't share it",
+			expected: []string{"Your code is 1234\r\nDon't share it", "Your code is 1234\nDon't share it"},
+		},
+		{
+			name:     "single line",
+			message:  "Your code is 1234",
+			expected: []string{"Your code is 1234"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			iterations := GetAllIterationsOfSMSMessage(testCase.message)
+			if !reflect.DeepEqual(iterations, testCase.expected) {
+				t.Errorf("expected %q, got %q", testCase.expected, iterations)
+			}
+		})
+	}
+}
+
+func TestNonBreakingSpaceTransformer(t *testing.T) {
+	testCases := []struct {
+		name     string
+		message  string
+		expected []string
+	}{
+		{
+			name:     "space before £",
+			message:  "You spent £5.00 at Pret",
+			expected: []string{"You spent £5.00 at Pret", "You spent\u00a0£5.00 at Pret"},
+		},
+		{
+			name:     "non-breaking space before £",
+			message:  "You spent\u00a0£5.00 at Pret",
+			expected: []string{"You spent £5.00 at Pret", "You spent\u00a0£5.00 at Pret"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			variants := NonBreakingSpaceTransformer.Apply(testCase.message)
+			if !reflect.DeepEqual(variants, testCase.expected) {
+				t.Errorf("expected %q, got %q", testCase.expected, variants)
+			}
+		})
+	}
+}
+
+func TestGetAllIterationsOfSMSMessageNonBreakingSpaces(t *testing.T) {
+	testCases := []struct {
+		name     string
+		message  string
+		expected []string
+	}{
+		{
+			name:     "non-breaking space before £",
+			message:  "You spent\u00a0£5.00",
+			expected: []string{"You spent\u00a0£5.00", "You spent £5.00"},
+		},
+		{
+			name:     "no currency symbol",
+			message:  "Your code is 1234",
+			expected: []string{"Your code is 1234"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			iterations := GetAllIterationsOfSMSMessage(testCase.message)
+			if !reflect.DeepEqual(iterations, testCase.expected) {
+				t.Errorf("expected %q, got %q", testCase.expected, iterations)
+			}
+		})
+	}
+}
+
+func TestWhitespaceCollapsingTransformer(t *testing.T) {
+	testCases := []struct {
+		name     string
+		message  string
+		expected []string
+	}{
+		{
+			name:     "double spaces between words",
+			message:  "Your  code is  1234",
+			expected: []string{"Your code is 1234"},
+		},
+		{
+			name:     "tabs and spaces",
+			message:  "Your \t code",
+			expected: []string{"Your code"},
+		},
+		{
+			name:     "line breaks and indentation kept",
+			message:  "Your  code\n  is 1234  \nThanks",
+			expected: []string{"Your code\n  is 1234  \nThanks"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			variants := WhitespaceCollapsingTransformer.Apply(testCase.message)
+			if !reflect.DeepEqual(variants, testCase.expected) {
+				t.Errorf("expected %q, got %q", testCase.expected, variants)
+			}
+		})
+	}
+}
+
+func TestGetAllIterationsOfSMSMessageCollapsesDoubleSpaces(t *testing.T) {
+	iterations := GetAllIterationsOfSMSMessage("Your  code is 1234")
+
+	expected := []string{"Your  code is 1234", "Your code is 1234"}
+	if !reflect.DeepEqual(iterations, expected) {
+		t.Errorf("expected %q, got %q", expected, iterations)
+	}
+}
+
+func TestURLTrailingSlashTransformer(t *testing.T) {
+	testCases := []struct {
+		name     string
+		message  string
+		expected []string
+	}{
+		{
+			name:     "URL with a trailing slash",
+			message:  "See https://monzo.me/x/ for details",
+			expected: []string{"See https://monzo.me/x for details", "See https://monzo.me/x/ for details"},
+		},
+		{
+			name:     "URL without a trailing slash",
+			message:  "See https://monzo.me/x for details",
+			expected: []string{"See https://monzo.me/x for details", "See https://monzo.me/x/ for details"},
+		},
+		{
+			name:     "URL ending a sentence",
+			message:  "See https://monzo.me/x/.",
+			expected: []string{"See https://monzo.me/x.", "See https://monzo.me/x/."},
+		},
+		{
+			name:     "URL with a query",
+			message:  "See https://monzo.me/x?a=b",
+			expected: []string{"See https://monzo.me/x?a=b", "See https://monzo.me/x?a=b"},
+		},
+		{
+			name:     "slash outside a URL",
+			message:  "Pay 1/2 now",
+			expected: []string{"Pay 1/2 now", "Pay 1/2 now"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			variants := URLTrailingSlashTransformer.Apply(testCase.message)
+			if !reflect.DeepEqual(variants, testCase.expected) {
+				t.Errorf("expected %q, got %q", testCase.expected, variants)
+			}
+		})
+	}
+}
+
+func TestGetAllIterationsOfSMSMessageURLTrailingSlash(t *testing.T) {
+	iterations := GetAllIterationsOfSMSMessage("See https://monzo.me/x/ for details")
+
+	expected := []string{"See https://monzo.me/x/ for details", "See https://monzo.me/x for details"}
+	if !reflect.DeepEqual(iterations, expected) {
+		t.Errorf("expected %q, got %q", expected, iterations)
+	}
+}
+
+func TestEmojiVariationSelectorTransformer(t *testing.T) {
+	testCases := []struct {
+		name     string
+		message  string
+		expected []string
+	}{
+		{
+			name:     "emoji without a variation selector",
+			message:  "Payment sent \u2705",
+			expected: []string{"Payment sent \u2705", "Payment sent \u2705\ufe0f"},
+		},
+		{
+			name:     "emoji with a variation selector",
+			message:  "Payment sent \u2705\ufe0f",
+			expected: []string{"Payment sent \u2705", "Payment sent \u2705\ufe0f"},
+		},
+		{
+			name:     "copyright sign",
+			message:  "Monzo \u00a9 2024",
+			expected: []string{"Monzo \u00a9 2024", "Monzo \u00a9\ufe0f 2024"},
+		},
+		{
+			name:     "registered sign with a variation selector",
+			message:  "Monzo\u00ae\ufe0f",
+			expected: []string{"Monzo\u00ae", "Monzo\u00ae\ufe0f"},
+		},
+		{
+			name:     "arrow which isn't an emoji",
+			message:  "Tap \u2190 to go back",
+			expected: nil,
+		},
+		{
+			name:     "box drawing",
+			message:  "\u2500\u2500 Receipt \u2500\u2500",
+			expected: nil,
+		},
+		{
+			name:     "digits",
+			message:  "Your code is 1234",
+			expected: nil,
+		},
+		{
+			name:     "no emoji",
+			message:  "Payment sent",
+			expected: nil,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			variants := EmojiVariationSelectorTransformer.Apply(testCase.message)
+			if !reflect.DeepEqual(variants, testCase.expected) {
+				t.Errorf("expected %q, got %q", testCase.expected, variants)
+			}
+		})
+	}
+}
+
+func TestGetAllIterationsOfSMSMessageEmojiVariationSelectors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		message  string
+		expected []string
+	}{
+		{
+			name:     "emoji without a variation selector",
+			message:  "Payment sent \u2705",
+			expected: []string{"Payment sent \u2705", "Payment sent \u2705\ufe0f"},
+		},
+		{
+			name:     "emoji with a variation selector",
+			message:  "Payment sent \u2705\ufe0f",
+			expected: []string{"Payment sent \u2705\ufe0f", "Payment sent \u2705"},
+		},
+		{
+			name:     "emoji with a skin tone modifier",
+			message:  "Thanks \U0001f44d\U0001f3fd",
+			expected: []string{"Thanks \U0001f44d\U0001f3fd"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			iterations := GetAllIterationsOfSMSMessage(testCase.message)
+			if !reflect.DeepEqual(iterations, testCase.expected) {
+				t.Errorf("expected %q, got %q", testCase.expected, iterations)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package verifiedsms
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/monzo/terrors"
+	"github.com/monzo/verifiedsms/oauth2"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrNumberNotVerified is returned by MarkSMSAsVerifiedStrict when the users' device doesn't support Verified SMS, so
+// callers can use errors.Is to fall back to sending a plain SMS
+var ErrNumberNotVerified = errors.New("phone number is not on Verified SMS")
+
+// ErrPartnerClosed is returned by requests made by a Partner after it has been closed
+var ErrPartnerClosed = errors.New("partner is closed")
+
+// ErrMultipleSegments is returned by ValidateMessageLength when a message doesn't fit in a single SMS
+var ErrMultipleSegments = errors.New("message is longer than a single SMS")
+
+// ErrInvalidArgument is the sub-code of the bad requests returned when an argument is rejected before any request is
+// made to Google, so they can be told apart from requests Google rejected with
+// terrors.Is(err, terrors.ErrBadRequest, ErrInvalidArgument)
+const ErrInvalidArgument = "invalid_argument"
+
+// NumberErrors describes why SMS couldn't be verified for some phone numbers in an operation across many numbers, it
+// maps each failed phone number to its error
+type NumberErrors map[string]error
+
+// Error summarises the failures, the individual errors can be read from the map
+func (errs NumberErrors) Error() string {
+	return "failed to verify SMS for " + strconv.Itoa(len(errs)) + " phone numbers"
+}
+
+// googleErrorResponse is the error envelope Google returns in the body of non-2xx responses
+type googleErrorResponse struct {
+	Error googleError `json:"error"`
+}
+
+type googleError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+// errorFromResponse returns an error describing a non-2xx response from Google, including the details from Google's
+// error envelope if the body contains one and the request ID to quote to Google's support
+func errorFromResponse(httpResponse *http.Response, requestID string) error {
+	params := map[string]string{
+		"http_status": strconv.Itoa(httpResponse.StatusCode),
+	}
+
+	if requestID != "" {
+		params["google_request_id"] = requestID
+	}
+
+	errorResponse := googleErrorResponse{}
+	_ = json.NewDecoder(httpResponse.Body).Decode(&errorResponse)
+
+	if errorResponse.Error.Message != "" {
+		params["google_message"] = errorResponse.Error.Message
+	}
+
+	if errorResponse.Error.Status != "" {
+		params["google_status"] = errorResponse.Error.Status
+	}
+
+	message := "bad response from Google: " + httpResponse.Status
+
+	if isInsufficientScope(httpResponse, errorResponse.Error) {
+		message += ", the service account's token must be granted the " + oauth2.Scope + " scope"
+		params["required_scope"] = oauth2.Scope
+	}
+
+	return errorFromGoogleError(message, errorResponse.Error, params)
+}
+
+// isInsufficientScope reports whether Google denied a request because the token used wasn't granted the scopes it
+// needs, which Google reports as PERMISSION_DENIED or with an insufficient_scope challenge
+func isInsufficientScope(httpResponse *http.Response, googleErr googleError) bool {
+	if httpResponse.StatusCode != http.StatusForbidden {
+		return false
+	}
+
+	if strings.Contains(httpResponse.Header.Get("WWW-Authenticate"), "insufficient_scope") {
+		return true
+	}
+
+	return googleErr.Status == "PERMISSION_DENIED" && strings.Contains(strings.ToLower(googleErr.Message), "scope")
+}
+
+// errorFromGoogleError maps the well-known statuses Google returns to the terrors they're most similar to
+func errorFromGoogleError(message string, googleErr googleError, params map[string]string) error {
+	switch googleErr.Status {
+	case "INVALID_ARGUMENT":
+		return terrors.BadRequest(terrors.ErrBadRequest, message, params)
+	case "FAILED_PRECONDITION":
+		return terrors.PreconditionFailed(terrors.ErrPreconditionFailed, message, params)
+	case "UNAUTHENTICATED":
+		return terrors.Unauthorized(terrors.ErrUnauthorized, message, params)
+	case "PERMISSION_DENIED":
+		return terrors.Forbidden(terrors.ErrForbidden, message, params)
+	case "NOT_FOUND":
+		return terrors.NotFound(terrors.ErrNotFound, message, params)
+	case "RESOURCE_EXHAUSTED":
+		return terrors.RateLimited(terrors.ErrRateLimited, message, params)
+	case "DEADLINE_EXCEEDED":
+		return terrors.Timeout(terrors.ErrTimeout, message, params)
+	default:
+		return terrors.InternalService(terrors.ErrInternalService, message, params)
+	}
+}
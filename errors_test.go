@@ -0,0 +1,65 @@
+package verifiedsms
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/monzo/verifiedsms/oauth2"
+)
+
+func TestInsufficientScopeErrorsNameTheRequiredScope(t *testing.T) {
+	testCases := []struct {
+		name            string
+		wwwAuthenticate string
+		body            string
+		expected        bool
+	}{
+		{
+			name:     "permission denied for the scope",
+			body:     `{"error":{"code":403,"message":"Request had insufficient authentication scopes.","status":"PERMISSION_DENIED"}}`,
+			expected: true,
+		},
+		{
+			name:            "insufficient scope challenge",
+			wwwAuthenticate: `Bearer realm="https://accounts.google.com/", error="insufficient_scope"`,
+			body:            `{"error":{"code":403,"message":"Forbidden","status":"PERMISSION_DENIED"}}`,
+			expected:        true,
+		},
+		{
+			name:     "permission denied for something else",
+			body:     `{"error":{"code":403,"message":"The caller does not have permission","status":"PERMISSION_DENIED"}}`,
+			expected: false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			partner := newTestPartner(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if testCase.wwwAuthenticate != "" {
+					w.Header().Set("WWW-Authenticate", testCase.wwwAuthenticate)
+				}
+
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(testCase.body))
+			}))
+
+			_, err := partner.GetPhoneNumberPublicKeys(context.Background(), "+447700900001")
+			if !terrors.Is(err, terrors.ErrForbidden) {
+				t.Fatalf("expected a forbidden error, got %v", err)
+			}
+
+			namesScope := strings.Contains(err.Error(), oauth2.Scope)
+			if namesScope != testCase.expected {
+				t.Errorf("expected naming the scope to be %v, got %q", testCase.expected, err.Error())
+			}
+
+			hasParam := err.(*terrors.Error).Params["required_scope"] == oauth2.Scope
+			if hasParam != testCase.expected {
+				t.Errorf("expected the required_scope param to be %v, got %v", testCase.expected, err.(*terrors.Error).Params)
+			}
+		})
+	}
+}
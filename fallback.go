@@ -0,0 +1,88 @@
+package verifiedsms
+
+import (
+	"context"
+	"github.com/monzo/terrors"
+)
+
+// SendPath describes how SendWithFallback handled a message
+type SendPath int
+
+const (
+	// SentVerified means the message was marked as verified, so it should be sent as the agent
+	SentVerified SendPath = iota
+
+	// SentFallback means the message couldn't be marked as verified, so it was sent by the fallback sender
+	SentFallback
+
+	// NotSent means the message wasn't sent at all, because the request was invalid or the context was done first
+	NotSent
+)
+
+// String returns a human readable name for the path, suitable for logging
+func (path SendPath) String() string {
+	switch path {
+	case SentVerified:
+		return "verified"
+	case SentFallback:
+		return "fallback"
+	case NotSent:
+		return "not_sent"
+	default:
+		return "unknown"
+	}
+}
+
+// FallbackSender sends smsMessage to phoneNumber as a plain SMS
+type FallbackSender func(ctx context.Context, phoneNumber string, smsMessage string) error
+
+// SendWithFallback marks a given SMS as verified for a given end users phone number when their device supports
+// Verified SMS, and otherwise sends it with fallback
+// The message is also sent with fallback if it couldn't be marked as verified because of an error, as the user should
+// still get it. The error is logged rather than returned, unless an argument is invalid, e.g. a nil agent, an invalid
+// phone number or an empty message, which is returned without sending anything as the fallback would hide a bug in the
+// caller. Requests Google rejects are still sent with fallback
+// Returns which path was taken, and an error if an argument was invalid, the fallback sender failed or the context was
+// done first
+func (partner Partner) SendWithFallback(ctx context.Context, phoneNumber string, agent *Agent, smsMessage string, fallback FallbackSender) (SendPath, error) {
+	if fallback == nil {
+		return NotSent, terrors.BadRequest(ErrInvalidArgument, "fallback sender must not be nil", nil)
+	}
+
+	if smsMessage == "" {
+		return NotSent, terrors.BadRequest(ErrInvalidArgument, "SMS message must not be empty", nil)
+	}
+
+	err := agent.validate(partner.HashingOptions)
+	if err != nil {
+		return NotSent, terrors.Propagate(err)
+	}
+
+	result, err := partner.MarkSMSAsVerifiedResult(ctx, phoneNumber, agent, smsMessage)
+	if err == nil && result.IsVerified() {
+		return SentVerified, nil
+	}
+
+	if terrors.Is(err, terrors.ErrBadRequest, ErrInvalidArgument) {
+		return NotSent, terrors.Propagate(err)
+	}
+
+	if err != nil {
+		partner.logger().Error(ctx, "failed to mark SMS as verified, falling back to plain SMS", map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	if ctx.Err() != nil {
+		return NotSent, terrors.Timeout(terrors.ErrTimeout, "context done before the fallback SMS was sent", map[string]string{
+			"error": ctx.Err().Error(),
+		})
+	}
+
+	err = fallback(ctx, phoneNumber, smsMessage)
+	if err != nil {
+		return SentFallback, terrors.Augment(err, "failed to send fallback SMS", nil)
+	}
+
+	return SentFallback, nil
+}
@@ -0,0 +1,119 @@
+package verifiedsms
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/monzo/terrors"
+)
+
+// recordingFallback is a FallbackSender which records the numbers it was asked to send to
+type recordingFallback struct {
+	sent []string
+}
+
+func (fallback *recordingFallback) send(ctx context.Context, phoneNumber string, smsMessage string) error {
+	fallback.sent = append(fallback.sent, phoneNumber)
+	return nil
+}
+
+func TestSendWithFallbackReturnsBadRequestsWithoutSending(t *testing.T) {
+	google := newFakeGoogle(nil)
+	partner := newTestPartner(t, google)
+
+	cases := map[string]struct {
+		phoneNumber string
+		agent       *Agent
+		smsMessage  string
+	}{
+		"invalid phone number": {
+			phoneNumber: "07700900001",
+			agent:       newTestAgent(t),
+			smsMessage:  "Your code is 1234",
+		},
+		"nil agent": {
+			phoneNumber: "+447700900001",
+			smsMessage:  "Your code is 1234",
+		},
+		"empty agent ID": {
+			phoneNumber: "+447700900001",
+			agent: &Agent{
+				PrivateKey: newTestAgent(t).PrivateKey,
+			},
+			smsMessage: "Your code is 1234",
+		},
+		"empty message": {
+			phoneNumber: "+447700900001",
+			agent:       newTestAgent(t),
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			fallback := &recordingFallback{}
+
+			path, err := partner.SendWithFallback(context.Background(), c.phoneNumber, c.agent, c.smsMessage, fallback.send)
+			if !terrors.Is(err, terrors.ErrBadRequest, ErrInvalidArgument) {
+				t.Errorf("expected an invalid argument, got %v", err)
+			}
+
+			if path != NotSent || len(fallback.sent) != 0 {
+				t.Errorf("expected nothing to be sent, got %s and %v", path, fallback.sent)
+			}
+		})
+	}
+}
+
+func TestSendWithFallbackFallsBackWhenGoogleFails(t *testing.T) {
+	partner := newTestPartner(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	fallback := &recordingFallback{}
+
+	path, err := partner.SendWithFallback(context.Background(), "+447700900001", newTestAgent(t), "Your code is 1234", fallback.send)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if path != SentFallback || len(fallback.sent) != 1 {
+		t.Errorf("expected the message to be sent with fallback, got %s and %v", path, fallback.sent)
+	}
+}
+
+func TestSendWithFallbackFallsBackWhenGoogleRejectsTheRequest(t *testing.T) {
+	partner := newTestPartner(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":{"code":400,"message":"Request contains an invalid argument.","status":"INVALID_ARGUMENT"}}`))
+	}))
+
+	fallback := &recordingFallback{}
+
+	path, err := partner.SendWithFallback(context.Background(), "+447700900001", newTestAgent(t), "Your code is 1234", fallback.send)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if path != SentFallback || len(fallback.sent) != 1 {
+		t.Errorf("expected the message to be sent with fallback, got %s and %v", path, fallback.sent)
+	}
+}
+
+func TestSendWithFallbackSendsVerified(t *testing.T) {
+	phoneNumber := "+447700900001"
+	partner := newTestPartner(t, newFakeGoogle(map[string][]string{
+		phoneNumber: {newTestUserKey(t)},
+	}))
+
+	fallback := &recordingFallback{}
+
+	path, err := partner.SendWithFallback(context.Background(), phoneNumber, newTestAgent(t), "Your code is 1234", fallback.send)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if path != SentVerified || len(fallback.sent) != 0 {
+		t.Errorf("expected the message to be verified, got %s and %v", path, fallback.sent)
+	}
+}
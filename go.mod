@@ -4,8 +4,13 @@ go 1.17
 
 require (
 	github.com/monzo/terrors v0.0.0-20211018135141-bff28203d17a
+	github.com/nyaruka/phonenumbers v1.1.2
+	go.opentelemetry.io/otel v1.10.0
+	go.opentelemetry.io/otel/trace v1.10.0
 	golang.org/x/crypto v0.0.0-20220131195533-30dcbda58838
 	golang.org/x/oauth2 v0.0.0-20211104180415-d3ed0bb246c8
+	golang.org/x/text v0.3.7
+	golang.org/x/time v0.3.0
 )
 
 require (
@@ -0,0 +1,141 @@
+package verifiedsms
+
+import (
+	"context"
+	"github.com/monzo/terrors"
+	"net/http"
+	"strconv"
+)
+
+// HashesExist checks whether the given base64 encoded message hashes are already stored on the Verified SMS service for
+// agent, so they don't need to be submitted again
+// Returns a map of each hash to whether it is stored
+func (partner Partner) HashesExist(ctx context.Context, agent *Agent, hashes []string) (map[string]bool, error) {
+	if agent == nil {
+		return nil, terrors.BadRequest(ErrInvalidArgument, "agent must not be nil", nil)
+	}
+
+	err := validateAgentID(agent.ID)
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
+
+	messagesToGoogle := make([]messageSubmissionToGoogle, 0, len(hashes))
+	for _, hash := range hashes {
+		messagesToGoogle = append(messagesToGoogle, messageSubmissionToGoogle{
+			Hash:    hash,
+			AgentId: agent.ID,
+		})
+	}
+
+	return partner.getExistingHashes(ctx, messagesToGoogle)
+}
+
+// MessageHashesExist checks whether the hashes for a given SMS message sent by agent to a given end users phone number
+// are already stored on the Verified SMS service, by computing every hash MarkSMSAsVerified would submit
+// Returns a map of each hash to whether it is stored, this will be empty if the users' device doesn't support Verified
+// SMS
+func (partner Partner) MessageHashesExist(ctx context.Context, phoneNumber string, agent *Agent, smsMessage string) (map[string]bool, error) {
+	err := agent.validate(partner.HashingOptions)
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
+
+	ctx, cancel := partner.withOperationTimeout(ctx)
+	defer cancel()
+
+	publicKeys, err := partner.GetPhoneNumberPublicKeys(ctx, phoneNumber)
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
+
+	if len(publicKeys) == 0 {
+		return map[string]bool{}, nil
+	}
+
+	messagesToGoogle, _, err := partner.getMessagesToGoogle(publicKeys, agent, smsMessage)
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
+
+	return partner.getExistingHashes(ctx, messagesToGoogle)
+}
+
+// getExistingHashes asks the Verified SMS service which of the given message hashes it has stored, in chunks of at
+// most the Partner's MaxBatchSize
+func (partner Partner) getExistingHashes(ctx context.Context, messagesToGoogle []messageSubmissionToGoogle) (map[string]bool, error) {
+	messagesToGoogle = dedupeMessages(messagesToGoogle)
+
+	exists := make(map[string]bool, len(messagesToGoogle))
+	for _, message := range messagesToGoogle {
+		exists[message.Hash] = false
+	}
+
+	for i, chunk := range partner.chunkMessages(messagesToGoogle) {
+		response := batchGetMessagesResponse{}
+
+		_, err := partner.doRequest(ctx, http.MethodPost, apiGetHashesPath, batchSubmitRequest{
+			Messages: chunk,
+		}, &response)
+		if err != nil {
+			return nil, terrors.Augment(err, "failed to get hashes from Google", map[string]string{
+				"chunk": strconv.Itoa(i),
+			})
+		}
+
+		for _, message := range response.Messages {
+			if _, ok := exists[message.Hash]; ok {
+				exists[message.Hash] = true
+			}
+		}
+	}
+
+	return exists, nil
+}
+
+// batchGetMessagesResponse lists the requested messages which Google has stored
+type batchGetMessagesResponse struct {
+	Messages []messageSubmissionToGoogle `json:"messages"`
+}
+
+// MessagesProduceSameHashes reports whether two SMS messages sent by agent to the device with a given base64 encoded
+// public key produce exactly the same set of hashes once every iteration of them is hashed, e.g. to check whether a
+// change to a message template would still match hashes already submitted
+func (partner Partner) MessagesProduceSameHashes(publicKey string, agent *Agent, smsMessageA string, smsMessageB string) (bool, error) {
+	hashesA, err := partner.hashSet(publicKey, agent, smsMessageA)
+	if err != nil {
+		return false, terrors.Propagate(err)
+	}
+
+	hashesB, err := partner.hashSet(publicKey, agent, smsMessageB)
+	if err != nil {
+		return false, terrors.Propagate(err)
+	}
+
+	if len(hashesA) != len(hashesB) {
+		return false, nil
+	}
+
+	for hash := range hashesA {
+		if !hashesB[hash] {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// hashSet returns the set of hashes for every iteration of smsMessage sent by agent to the device with publicKey
+func (partner Partner) hashSet(publicKey string, agent *Agent, smsMessage string) (map[string]bool, error) {
+	messagesToGoogle, _, err := partner.getMessagesToGoogle([]string{publicKey}, agent, smsMessage)
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
+
+	hashes := make(map[string]bool, len(messagesToGoogle))
+	for _, message := range messagesToGoogle {
+		hashes[message.Hash] = true
+	}
+
+	return hashes, nil
+}
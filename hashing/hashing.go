@@ -4,32 +4,146 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/sha256"
+	"crypto/subtle"
 	"crypto/x509"
 	"encoding/base64"
 	"github.com/monzo/terrors"
 	"golang.org/x/crypto/hkdf"
 	"io"
+	"strconv"
 )
 
+// DefaultHashLength is the number of bytes in the hashes the Verified SMS service expects
+const DefaultHashLength = 32
+
+// maxHashLength is the most output HKDF-SHA256 can produce
+const maxHashLength = 255 * sha256.Size
+
+// Options configures how hashes are derived, the zero value derives hashes in the way the Verified SMS service expects
+type Options struct {
+	// HashLength is the number of bytes in each hash, if zero DefaultHashLength is used
+	HashLength int
+
+	// Curves are the elliptic curves keys are allowed to use, if empty only elliptic.P384 (secp384r1), which Verified
+	// SMS uses, is allowed. Other curves are only useful for testing
+	Curves []elliptic.Curve
+
+	// Salt is the HKDF salt. Verified SMS doesn't use a salt, so it should be nil unless testing other parameters
+	Salt []byte
+
+	// Info derives the HKDF info from the message. If nil the message itself is used, as Verified SMS expects
+	Info func(smsMessage []byte) []byte
+}
+
 // GetHashForSMSMessage returns the hash for a given SMS message sent by a given agent to a user with a given public key
+// smsMessage is hashed byte for byte, it is never normalised or re-encoded, so UTF-8 content in any script hashes the
+// same as the bytes the device receives. Any variants, such as Unicode normalisation forms, must be produced by the
+// caller
 func GetHashForSMSMessage(publicKeyString string, agentPrivateKey *ecdsa.PrivateKey, smsMessage []byte) ([]byte, error) {
-	publicKey, err := getPublicKeyFromPublicKeyPayload(publicKeyString)
+	publicKey, err := ParsePublicKey(publicKeyString)
 	if err != nil {
 		return nil, terrors.Propagate(err)
 	}
 
-	sharedSecret, err := ecdhDeriveSecret(agentPrivateKey, publicKey)
+	return GetHashForSMSMessageWithPublicKey(publicKey, agentPrivateKey, smsMessage)
+}
+
+// GetHashForSMSMessageWithPublicKey returns the hash for a given SMS message sent by a given agent to a user with a
+// given public key which has already been parsed with ParsePublicKey, so the same key can be used to hash many messages
+func GetHashForSMSMessageWithPublicKey(publicKey *ecdsa.PublicKey, agentPrivateKey *ecdsa.PrivateKey, smsMessage []byte) ([]byte, error) {
+	return Options{}.GetHashForSMSMessageWithPublicKey(publicKey, agentPrivateKey, smsMessage)
+}
+
+// GetHashForSMSMessageWithPublicKey returns the hash for a given SMS message sent by a given agent to a user with a
+// given public key, derived according to options
+func (options Options) GetHashForSMSMessageWithPublicKey(publicKey *ecdsa.PublicKey, agentPrivateKey *ecdsa.PrivateKey, smsMessage []byte) ([]byte, error) {
+	sharedSecret, err := options.DeriveSharedSecret(publicKey, agentPrivateKey)
 	if err != nil {
 		return nil, terrors.Propagate(err)
 	}
 
-	return deriveHashForSMSMessage(sharedSecret, smsMessage)
+	return options.GetHashForSMSMessageWithSharedSecret(sharedSecret, smsMessage)
+}
+
+// DeriveSharedSecret derives the ECDH shared secret between a given agent and a user with a given public key
+// The shared secret only depends on the pair of keys, so it can be derived once and passed to
+// GetHashForSMSMessageWithSharedSecret to hash every message sent by the agent to the user
+func DeriveSharedSecret(publicKey *ecdsa.PublicKey, agentPrivateKey *ecdsa.PrivateKey) ([]byte, error) {
+	return Options{}.DeriveSharedSecret(publicKey, agentPrivateKey)
 }
 
-func deriveHashForSMSMessage(sharedSecret []byte, smsMessageContent []byte) ([]byte, error) {
-	kdf := hkdf.New(sha256.New, sharedSecret, nil, smsMessageContent)
+// DeriveSharedSecret derives the ECDH shared secret between a given agent and a user with a given public key,
+// validating the keys according to options
+func (options Options) DeriveSharedSecret(publicKey *ecdsa.PublicKey, agentPrivateKey *ecdsa.PrivateKey) ([]byte, error) {
+	err := options.ValidatePrivateKey(agentPrivateKey)
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
 
-	hash := make([]byte, 32)
+	return options.ecdhDeriveSecret(agentPrivateKey, publicKey)
+}
+
+// GetHashForSMSMessageWithSharedSecret returns the hash for a given SMS message using a shared secret from
+// DeriveSharedSecret
+func GetHashForSMSMessageWithSharedSecret(sharedSecret []byte, smsMessage []byte) ([]byte, error) {
+	return Options{}.GetHashForSMSMessageWithSharedSecret(sharedSecret, smsMessage)
+}
+
+// GetHashForSMSMessageWithSharedSecret returns the hash for a given SMS message using a shared secret from
+// DeriveSharedSecret, derived according to options
+func (options Options) GetHashForSMSMessageWithSharedSecret(sharedSecret []byte, smsMessage []byte) ([]byte, error) {
+	hashLength, err := options.hashLength()
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
+
+	info, err := options.info(smsMessage)
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
+
+	return deriveHashForSMSMessage(sharedSecret, options.Salt, info, hashLength)
+}
+
+// hashLength returns the number of bytes in each hash, checking it's something HKDF can produce
+func (options Options) hashLength() (int, error) {
+	if options.HashLength == 0 {
+		return DefaultHashLength, nil
+	}
+
+	if options.HashLength < 0 || options.HashLength > maxHashLength {
+		return 0, terrors.BadRequest(
+			terrors.ErrBadRequest,
+			"hash length must be between 1 and "+strconv.Itoa(maxHashLength)+" bytes",
+			map[string]string{
+				"hash_length": strconv.Itoa(options.HashLength),
+			},
+		)
+	}
+
+	return options.HashLength, nil
+}
+
+// info returns the HKDF info for smsMessage, checking it isn't empty as every message would then have the same hash
+func (options Options) info(smsMessage []byte) ([]byte, error) {
+	if options.Info == nil {
+		return smsMessage, nil
+	}
+
+	info := options.Info(smsMessage)
+	if len(info) == 0 {
+		return nil, terrors.BadRequest(terrors.ErrBadRequest, "HKDF info must not be empty", nil)
+	}
+
+	return info, nil
+}
+
+// deriveHashForSMSMessage derives the hash with HKDF-SHA256. The Verified SMS spec uses the ECDH shared secret as the
+// input key material, no salt and the exact bytes of the message as the info
+func deriveHashForSMSMessage(sharedSecret []byte, salt []byte, info []byte, hashLength int) ([]byte, error) {
+	kdf := hkdf.New(sha256.New, sharedSecret, salt, info)
+
+	hash := make([]byte, hashLength)
 
 	_, err := io.ReadFull(kdf, hash)
 
@@ -40,28 +154,147 @@ func deriveHashForSMSMessage(sharedSecret []byte, smsMessageContent []byte) ([]b
 	return hash, nil
 }
 
-func ecdhDeriveSecret(privateKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey) ([]byte, error) {
-	ecdhCurve := elliptic.P384()
+// ValidatePrivateKey checks an agent private key is on the curve used by Verified SMS
+func ValidatePrivateKey(privateKey *ecdsa.PrivateKey) error {
+	return Options{}.ValidatePrivateKey(privateKey)
+}
 
-	if !ecdhCurve.IsOnCurve(publicKey.X, publicKey.Y) {
-		return nil, terrors.PreconditionFailed(
+// ValidatePrivateKey checks the agent private key is on an approved curve, otherwise we'd silently derive hashes which
+// will never match
+func (options Options) ValidatePrivateKey(privateKey *ecdsa.PrivateKey) error {
+	if privateKey == nil {
+		return terrors.PreconditionFailed(terrors.ErrPreconditionFailed, "agent private key must not be nil", nil)
+	}
+
+	if !options.isApprovedCurve(privateKey.Curve) {
+		return terrors.PreconditionFailed(
+			terrors.ErrPreconditionFailed,
+			"Verified SMS Agent Private Keys should be on curve secp384r1 (elliptic.P384) but this private key is "+
+				"not on this curve.",
+			map[string]string{
+				"private_key.curve_name": curveName(privateKey.Curve),
+			},
+		)
+	}
+
+	return nil
+}
+
+// ValidatePublicKey checks a users' public key is on the curve used by Verified SMS
+func ValidatePublicKey(publicKey *ecdsa.PublicKey) error {
+	return Options{}.ValidatePublicKey(publicKey)
+}
+
+// ValidatePublicKey checks a users' public key claims to use an approved curve and is actually on that curve
+func (options Options) ValidatePublicKey(publicKey *ecdsa.PublicKey) error {
+	if publicKey == nil {
+		return terrors.PreconditionFailed(terrors.ErrPreconditionFailed, "public key must not be nil", nil)
+	}
+
+	if publicKey.Curve == nil || publicKey.X == nil || publicKey.Y == nil {
+		return terrors.PreconditionFailed(terrors.ErrPreconditionFailed, "public key must have a curve and coordinates", map[string]string{
+			"public_key.curve_name": curveName(publicKey.Curve),
+		})
+	}
+
+	if !options.isApprovedCurve(publicKey.Curve) || !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+		return terrors.PreconditionFailed(
 			terrors.ErrPreconditionFailed,
 			"Verified SMS Public Keys should be on curve secp384r1 (elliptic.P384) but this public key is "+
 				"not on this curve.",
 			map[string]string{
 				"public_key.x":          publicKey.X.String(),
 				"public_key.y":          publicKey.Y.String(),
-				"public_key.curve_name": publicKey.Curve.Params().Name,
+				"public_key.curve_name": curveName(publicKey.Curve),
 			},
 		)
 	}
 
-	sharedSecret, _ := ecdhCurve.ScalarMult(publicKey.X, publicKey.Y, privateKey.D.Bytes())
+	return nil
+}
+
+// isApprovedCurve returns whether keys on curve can be used to derive hashes
+func (options Options) isApprovedCurve(curve elliptic.Curve) bool {
+	if curve == nil {
+		return false
+	}
+
+	if len(options.Curves) == 0 {
+		return curve == elliptic.P384()
+	}
+
+	for _, approvedCurve := range options.Curves {
+		if curve == approvedCurve {
+			return true
+		}
+	}
+
+	return false
+}
+
+func curveName(curve elliptic.Curve) string {
+	if curve == nil {
+		return "unknown"
+	}
+
+	return curve.Params().Name
+}
+
+// ecdhDeriveSecret derives the shared secret on the public key's own curve, which must be approved and the same as the
+// private key's
+func (options Options) ecdhDeriveSecret(privateKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey) ([]byte, error) {
+	err := options.ValidatePublicKey(publicKey)
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
+
+	ecdhCurve := publicKey.Curve
+
+	if privateKey.Curve != ecdhCurve {
+		return nil, terrors.PreconditionFailed(
+			terrors.ErrPreconditionFailed,
+			"The agent private key and the users' public key must be on the same curve",
+			map[string]string{
+				"private_key.curve_name": curveName(privateKey.Curve),
+				"public_key.curve_name":  curveName(publicKey.Curve),
+			},
+		)
+	}
+
+	sharedX, sharedY := ecdhCurve.ScalarMult(publicKey.X, publicKey.Y, privateKey.D.Bytes())
+
+	// The point at infinity is returned as (0, 0), it and any other zero secret would give hashes anyone could derive
+	if sharedX.Sign() == 0 {
+		return nil, terrors.PreconditionFailed(
+			terrors.ErrPreconditionFailed,
+			"The shared secret derived from the agent private key and the users' public key is degenerate",
+			map[string]string{
+				"public_key.curve_name": curveName(publicKey.Curve),
+				"shared_point.y_zero":   strconv.FormatBool(sharedY.Sign() == 0),
+			},
+		)
+	}
+
+	// The secret is the X coordinate zero padded to the curve's field size, 48 bytes for P-384, as other
+	// implementations do. big.Int.Bytes drops leading zeros, which would give a different hash for 1 in 256 keys
+	fieldSize := (ecdhCurve.Params().BitSize + 7) / 8
+	if (sharedX.BitLen()+7)/8 > fieldSize {
+		return nil, terrors.PreconditionFailed(
+			terrors.ErrPreconditionFailed,
+			"The shared secret derived from the agent private key and the users' public key is longer than the curve's "+
+				"field size",
+			map[string]string{
+				"public_key.curve_name": curveName(publicKey.Curve),
+				"shared_secret_length":  strconv.Itoa((sharedX.BitLen() + 7) / 8),
+			},
+		)
+	}
 
-	return sharedSecret.Bytes(), nil
+	return sharedX.FillBytes(make([]byte, fieldSize)), nil
 }
 
-func getPublicKeyFromPublicKeyPayload(publicKeyPayload string) (*ecdsa.PublicKey, error) {
+// ParsePublicKey parses a users' public key from the base64 encoded PKIX form returned by the Verified SMS service
+func ParsePublicKey(publicKeyPayload string) (*ecdsa.PublicKey, error) {
 	publicKeyBytes, err := base64.StdEncoding.DecodeString(publicKeyPayload)
 
 	if err != nil {
@@ -81,3 +314,66 @@ func getPublicKeyFromPublicKeyPayload(publicKeyPayload string) (*ecdsa.PublicKey
 
 	return ecdsaPublicKey, nil
 }
+
+// VerifyHash reports whether expectedHash, base64 encoded, is the hash for a given SMS message sent by a given agent to
+// a user with a given public key, e.g. to check hashes received from other systems
+// The hashes are compared in constant time
+func VerifyHash(publicKey *ecdsa.PublicKey, agentPrivateKey *ecdsa.PrivateKey, smsMessage []byte, expectedHash string) (bool, error) {
+	return Options{}.VerifyHash(publicKey, agentPrivateKey, smsMessage, expectedHash)
+}
+
+// VerifyHash reports whether expectedHash, base64 encoded, is the hash for a given SMS message sent by a given agent to
+// a user with a given public key, derived according to options
+func (options Options) VerifyHash(publicKey *ecdsa.PublicKey, agentPrivateKey *ecdsa.PrivateKey, smsMessage []byte, expectedHash string) (bool, error) {
+	expectedHashBytes, err := decodeBase64Hash(expectedHash)
+	if err != nil {
+		return false, terrors.Propagate(err)
+	}
+
+	hash, err := options.GetHashForSMSMessageWithPublicKey(publicKey, agentPrivateKey, smsMessage)
+	if err != nil {
+		return false, terrors.Propagate(err)
+	}
+
+	return HashesEqual(hash, expectedHashBytes), nil
+}
+
+// HashesEqual reports whether two hashes are equal in constant time
+// Callers should use this rather than comparing hashes directly for any security-sensitive comparison, as the time an
+// ordinary comparison takes leaks how much of the hashes match
+func HashesEqual(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+// Base64HashesEqual reports whether two base64 encoded hashes are equal in constant time, decoding them first so
+// differences in padding or encoding can't cause a mismatch
+func Base64HashesEqual(a, b string) (bool, error) {
+	aBytes, err := decodeBase64Hash(a)
+	if err != nil {
+		return false, terrors.Propagate(err)
+	}
+
+	bBytes, err := decodeBase64Hash(b)
+	if err != nil {
+		return false, terrors.Propagate(err)
+	}
+
+	return HashesEqual(aBytes, bBytes), nil
+}
+
+// decodeBase64Hash decodes a hash encoded with either the standard or URL safe base64 alphabet, with or without padding
+func decodeBase64Hash(hash string) ([]byte, error) {
+	for _, encoding := range []*base64.Encoding{
+		base64.StdEncoding,
+		base64.RawStdEncoding,
+		base64.URLEncoding,
+		base64.RawURLEncoding,
+	} {
+		decoded, err := encoding.DecodeString(hash)
+		if err == nil {
+			return decoded, nil
+		}
+	}
+
+	return nil, terrors.BadRequest(terrors.ErrBadRequest, "hash is not valid base64", nil)
+}
@@ -0,0 +1,313 @@
+package hashing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/monzo/terrors"
+)
+
+// The keys the known vectors were derived with. The hashes were checked against an independent ECDH and HKDF-SHA256
+// implementation, so a change to any of them means hashes no longer match what devices compute
+const (
+	testAgentPrivateKeyD = "6b9d3dad2e1b8c1c05b19875b6659f4de23c3b667bf297ba9aa47740787137d896d5724e4c70a825f872c9ea60d2edf5"
+	testUserPrivateKeyD  = "a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f90"
+
+	// testUserPublicKey is the public key for testUserPrivateKeyD, in the form Google returns it
+	testUserPublicKey = "MHYwEAYHKoZIzj0CAQYFK4EEACIDYgAEUlolmkyMe2zS/z1HoEJ8oTU1YJIHdotMMfgORb0aK4Y6/TJfwXxbwTocBWw0pDtAmGdXX3" +
+		"RFQ8xEOkTFyNA9jDvkgYCHvrNHSoRtiBGPtnaz0vFQxDKz1nFstWjfQ89j"
+
+	testSharedSecret = "19fb5b54c203ec1752c796d35b9e57aaafa4b149f3f9739ddb6bfffb72e9fb96076fc6d8319921423bff0853d5945533"
+)
+
+// newTestPrivateKey returns the P-384 private key with the hex encoded scalar d
+func newTestPrivateKey(t *testing.T, d string) *ecdsa.PrivateKey {
+	t.Helper()
+
+	scalar, ok := new(big.Int).SetString(d, 16)
+	if !ok {
+		t.Fatalf("invalid private key scalar %q", d)
+	}
+
+	privateKey := &ecdsa.PrivateKey{D: scalar}
+	privateKey.Curve = elliptic.P384()
+	privateKey.X, privateKey.Y = privateKey.Curve.ScalarBaseMult(scalar.Bytes())
+
+	return privateKey
+}
+
+func TestTestUserPublicKeyMatchesItsPrivateKey(t *testing.T) {
+	publicKey, err := ParsePublicKey(testUserPublicKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	userPrivateKey := newTestPrivateKey(t, testUserPrivateKeyD)
+	if publicKey.X.Cmp(userPrivateKey.X) != 0 || publicKey.Y.Cmp(userPrivateKey.Y) != 0 {
+		t.Errorf("expected testUserPublicKey to be the public key for testUserPrivateKeyD")
+	}
+}
+
+func TestDeriveSharedSecretKnownVector(t *testing.T) {
+	publicKey, err := ParsePublicKey(testUserPublicKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sharedSecret, err := DeriveSharedSecret(publicKey, newTestPrivateKey(t, testAgentPrivateKeyD))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if hex.EncodeToString(sharedSecret) != testSharedSecret {
+		t.Errorf("expected shared secret %s, got %x", testSharedSecret, sharedSecret)
+	}
+}
+
+func TestGetHashForSMSMessageKnownVectors(t *testing.T) {
+	cases := []struct {
+		name       string
+		smsMessage string
+		hash       string
+	}{
+		{
+			name:       "ASCII",
+			smsMessage: "Your code is 1234",
+			hash:       "wLIfSqDpHUngV7TIxqYOMEFOiOepOzZxvh+9dTvKvSE=",
+		},
+		{
+			name:       "accented and currency",
+			smsMessage: "Café £5 – ünïcødé",
+			hash:       "ubI48Q8keE1rzJ5ON6KZKvnQ0fydczMZCHNmGiYKV00=",
+		},
+		{
+			name:       "CJK",
+			smsMessage: "日本語のメッセージ",
+			hash:       "6AJd8e0d+4BQlDVFPbn07WX3wN0ElbIX5ViItLb5sys=",
+		},
+		{
+			name:       "emoji",
+			smsMessage: "Thanks \u2705",
+			hash:       "vExCgnT+iPH6DKx1z66wPu3zFd7A2HsyohuvuhUCCUs=",
+		},
+		{
+			name:       "emoji with variation selector",
+			smsMessage: "Thanks \u2705\ufe0f",
+			hash:       "xGjTtUIzcGNG1aO/zNPsWzevAVkmvGXRV35MJ9jxBfE=",
+		},
+		{
+			name:       "astral emoji with skin tone modifier",
+			smsMessage: "\U0001f44b\U0001f3fd hi",
+			hash:       "4QZM6Nmj6bNvlk1N3F46ahvqRmbYY9+E2YwaFMZYAGs=",
+		},
+	}
+
+	agentPrivateKey := newTestPrivateKey(t, testAgentPrivateKeyD)
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			hash, err := GetHashForSMSMessage(testUserPublicKey, agentPrivateKey, []byte(c.smsMessage))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if encoded := base64.StdEncoding.EncodeToString(hash); encoded != c.hash {
+				t.Errorf("expected %s, got %s", c.hash, encoded)
+			}
+		})
+	}
+}
+
+func TestGetHashForSMSMessageWithSharedSecretMatchesPublicKey(t *testing.T) {
+	sharedSecret, err := hex.DecodeString(testSharedSecret)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	hash, err := GetHashForSMSMessageWithSharedSecret(sharedSecret, []byte("Your code is 1234"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if encoded := base64.StdEncoding.EncodeToString(hash); encoded != "wLIfSqDpHUngV7TIxqYOMEFOiOepOzZxvh+9dTvKvSE=" {
+		t.Errorf("expected the same hash as from the public key, got %s", encoded)
+	}
+}
+
+func TestVerifyHashKnownVector(t *testing.T) {
+	publicKey, err := ParsePublicKey(testUserPublicKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	agentPrivateKey := newTestPrivateKey(t, testAgentPrivateKeyD)
+
+	cases := []struct {
+		name         string
+		smsMessage   string
+		expectedHash string
+		matches      bool
+	}{
+		{
+			name:         "matching hash",
+			smsMessage:   "Your code is 1234",
+			expectedHash: "wLIfSqDpHUngV7TIxqYOMEFOiOepOzZxvh+9dTvKvSE=",
+			matches:      true,
+		},
+		{
+			name:         "different message",
+			smsMessage:   "Your code is 1235",
+			expectedHash: "wLIfSqDpHUngV7TIxqYOMEFOiOepOzZxvh+9dTvKvSE=",
+		},
+		{
+			name:         "altered hash",
+			smsMessage:   "Your code is 1234",
+			expectedHash: "xLIfSqDpHUngV7TIxqYOMEFOiOepOzZxvh+9dTvKvSE=",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			matches, err := VerifyHash(publicKey, agentPrivateKey, []byte(c.smsMessage), c.expectedHash)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if matches != c.matches {
+				t.Errorf("expected %v, got %v", c.matches, matches)
+			}
+		})
+	}
+}
+
+func TestVerifyHashRejectsInvalidBase64(t *testing.T) {
+	publicKey, err := ParsePublicKey(testUserPublicKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = VerifyHash(publicKey, newTestPrivateKey(t, testAgentPrivateKeyD), []byte("Your code is 1234"), "not base64!")
+	if err == nil {
+		t.Errorf("expected an error for a hash which isn't base64")
+	}
+}
+
+func TestDeriveSharedSecretKeepsLeadingZeroBytes(t *testing.T) {
+	// The shared X coordinate for this user key and the test agent key starts with a zero byte, which big.Int.Bytes
+	// would drop
+	userPublicKey := "MHYwEAYHKoZIzj0CAQYFK4EEACIDYgAEn5YcHssx42N+KKs5H3nX5CpD4t63K/CPkKV3IBeeH7c950VNXlz64A24mVST51HpivlQ" +
+		"PcMUwmoZNA6MSLdpmmr7BgjT15HNtxj79Jn2ghXAVwwe5BhHyAcxCEYDU+bg"
+
+	publicKey, err := ParsePublicKey(userPublicKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	agentPrivateKey := newTestPrivateKey(t, testAgentPrivateKeyD)
+
+	sharedSecret, err := DeriveSharedSecret(publicKey, agentPrivateKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectedSecret := "001756b42c8729cdd6526f9b261a7d181da301d90732019a08f53aba7127af383bf01ef9afe1b10c6da3f4e29b7db831"
+	if len(sharedSecret) != 48 || hex.EncodeToString(sharedSecret) != expectedSecret {
+		t.Errorf("expected the 48 byte secret %s, got %d bytes %x", expectedSecret, len(sharedSecret), sharedSecret)
+	}
+
+	hash, err := GetHashForSMSMessageWithPublicKey(publicKey, agentPrivateKey, []byte("Your code is 1234"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The hash of the unpadded 47 byte secret would be W8oTQtYJYJcEgAIDKTAIBrC9A3toirQJ3MT0iW5kBq0=
+	if encoded := base64.StdEncoding.EncodeToString(hash); encoded != "PhAnHEvGV0NhMfz0eM4+N2pFM5Uh3TKl9aqHpQrT2Uc=" {
+		t.Errorf("expected the hash of the padded secret, got %s", encoded)
+	}
+}
+
+func TestDeriveSharedSecretRejectsDegenerateKeys(t *testing.T) {
+	userPrivateKey := newTestPrivateKey(t, testUserPrivateKeyD)
+	agentPrivateKey := newTestPrivateKey(t, testAgentPrivateKeyD)
+
+	// An agent key whose scalar is the order of the curve multiplies every point to the point at infinity
+	orderPrivateKey := &ecdsa.PrivateKey{D: elliptic.P384().Params().N}
+	orderPrivateKey.PublicKey = agentPrivateKey.PublicKey
+
+	cases := []struct {
+		name            string
+		publicKey       *ecdsa.PublicKey
+		agentPrivateKey *ecdsa.PrivateKey
+	}{
+		{
+			name:            "point at infinity",
+			publicKey:       &ecdsa.PublicKey{Curve: elliptic.P384(), X: big.NewInt(0), Y: big.NewInt(0)},
+			agentPrivateKey: agentPrivateKey,
+		},
+		{
+			name: "point not on the curve",
+			publicKey: &ecdsa.PublicKey{
+				Curve: elliptic.P384(),
+				X:     userPrivateKey.X,
+				Y:     new(big.Int).Add(userPrivateKey.Y, big.NewInt(1)),
+			},
+			agentPrivateKey: agentPrivateKey,
+		},
+		{
+			name:            "shared point at infinity",
+			publicKey:       &userPrivateKey.PublicKey,
+			agentPrivateKey: orderPrivateKey,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sharedSecret, err := DeriveSharedSecret(c.publicKey, c.agentPrivateKey)
+			if !terrors.Is(err, terrors.ErrPreconditionFailed) {
+				t.Errorf("expected a precondition failed error, got %x, %v", sharedSecret, err)
+			}
+		})
+	}
+}
+
+func TestValidatePublicKeyRejectsIncompleteKeys(t *testing.T) {
+	curve := elliptic.P384()
+
+	testCases := []struct {
+		name      string
+		publicKey *ecdsa.PublicKey
+	}{
+		{
+			name: "nil key",
+		},
+		{
+			name:      "zero key",
+			publicKey: &ecdsa.PublicKey{},
+		},
+		{
+			name:      "nil curve",
+			publicKey: &ecdsa.PublicKey{X: curve.Params().Gx, Y: curve.Params().Gy},
+		},
+		{
+			name:      "nil x",
+			publicKey: &ecdsa.PublicKey{Curve: curve, Y: curve.Params().Gy},
+		},
+		{
+			name:      "nil y",
+			publicKey: &ecdsa.PublicKey{Curve: curve, X: curve.Params().Gx},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := ValidatePublicKey(testCase.publicKey)
+			if !terrors.Is(err, terrors.ErrPreconditionFailed) {
+				t.Errorf("expected a precondition failed error, got %v", err)
+			}
+		})
+	}
+}
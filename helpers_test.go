@@ -0,0 +1,148 @@
+package verifiedsms
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	xoauth2 "golang.org/x/oauth2"
+)
+
+// newTestPartner returns a Partner which sends its requests to handler, authenticated with a static token
+func newTestPartner(t *testing.T, handler http.Handler) Partner {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	return Partner{
+		BaseURL:     server.URL,
+		TokenSource: xoauth2.StaticTokenSource(&xoauth2.Token{AccessToken: "token"}),
+	}
+}
+
+// newTestAgent returns an agent with a new P-384 private key
+func newTestAgent(t *testing.T) *Agent {
+	t.Helper()
+
+	return &Agent{
+		ID:         "test-agent",
+		PrivateKey: newTestPrivateKey(t, elliptic.P384()),
+	}
+}
+
+func newTestPrivateKey(t *testing.T, curve elliptic.Curve) *ecdsa.PrivateKey {
+	t.Helper()
+
+	privateKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	return privateKey
+}
+
+// newTestUserKey returns a base64 encoded PKIX public key for a new P-384 user key, as Google returns them
+func newTestUserKey(t *testing.T) string {
+	t.Helper()
+
+	return newTestUserKeyOnCurve(t, elliptic.P384())
+}
+
+// newTestUserKeyOnCurve returns a base64 encoded PKIX public key for a new user key on curve
+func newTestUserKeyOnCurve(t *testing.T, curve elliptic.Curve) string {
+	t.Helper()
+
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(newTestPrivateKey(t, curve).Public())
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %v", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(publicKeyBytes)
+}
+
+// fakeGoogle is a fake of the Verified SMS API which returns publicKeys for each phone number and stores every hash
+// submitted to it
+type fakeGoogle struct {
+	publicKeys map[string][]string
+
+	mu       sync.Mutex
+	requests map[string]int
+	created  []batchSubmitRequest
+	deleted  []batchSubmitRequest
+}
+
+func newFakeGoogle(publicKeys map[string][]string) *fakeGoogle {
+	return &fakeGoogle{
+		publicKeys: publicKeys,
+		requests:   map[string]int{},
+	}
+}
+
+func (google *fakeGoogle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	google.mu.Lock()
+	google.requests[r.URL.Path]++
+	google.mu.Unlock()
+
+	switch r.URL.Path {
+	case apiGetPublicKeysPath:
+		request := struct {
+			PhoneNumbers []string `json:"phoneNumbers"`
+		}{}
+		_ = json.NewDecoder(r.Body).Decode(&request)
+
+		response := verifiedSMSResponse{}
+		for _, phoneNumber := range request.PhoneNumbers {
+			for _, publicKey := range google.publicKeys[phoneNumber] {
+				response.UserKeys = append(response.UserKeys, verifiedSMSResponseUserKeys{
+					PhoneNumber: phoneNumber,
+					PublicKey:   publicKey,
+				})
+			}
+		}
+
+		_ = json.NewEncoder(w).Encode(response)
+	case apiSubmitHashesPath, apiDeleteHashesPath:
+		request := batchSubmitRequest{}
+		_ = json.NewDecoder(r.Body).Decode(&request)
+
+		google.mu.Lock()
+		if r.URL.Path == apiSubmitHashesPath {
+			google.created = append(google.created, request)
+		} else {
+			google.deleted = append(google.deleted, request)
+		}
+		google.mu.Unlock()
+
+		_, _ = w.Write([]byte("{}"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// requestCount returns the number of requests made to the API path
+func (google *fakeGoogle) requestCount(path string) int {
+	google.mu.Lock()
+	defer google.mu.Unlock()
+
+	return google.requests[path]
+}
+
+// hashes returns every hash in requests
+func hashes(requests []batchSubmitRequest) []string {
+	var hashes []string
+	for _, request := range requests {
+		for _, message := range request.Messages {
+			hashes = append(hashes, message.Hash)
+		}
+	}
+
+	return hashes
+}
@@ -0,0 +1,65 @@
+package verifiedsms
+
+import (
+	"context"
+)
+
+// Logger receives structured log lines from the Partner
+// Message content and hashes are never logged, as they could be used to reconstruct what was sent to the user
+type Logger interface {
+	Debug(ctx context.Context, msg string, params map[string]string)
+	Info(ctx context.Context, msg string, params map[string]string)
+	Error(ctx context.Context, msg string, params map[string]string)
+}
+
+// noopLogger discards everything, it's used when a Partner doesn't have a Logger
+type noopLogger struct{}
+
+func (noopLogger) Debug(context.Context, string, map[string]string) {}
+func (noopLogger) Info(context.Context, string, map[string]string)  {}
+func (noopLogger) Error(context.Context, string, map[string]string) {}
+
+// logger returns the Partner's Logger, or a Logger which discards everything if it doesn't have one
+func (partner Partner) logger() Logger {
+	if partner.Logger == nil {
+		return noopLogger{}
+	}
+
+	return correlatingLogger{
+		logger: partner.Logger,
+	}
+}
+
+// correlatingLogger adds the correlation ID from the context to the params of every log line, if there is one
+type correlatingLogger struct {
+	logger Logger
+}
+
+func (logger correlatingLogger) Debug(ctx context.Context, msg string, params map[string]string) {
+	logger.logger.Debug(ctx, msg, withCorrelationIDParam(ctx, params))
+}
+
+func (logger correlatingLogger) Info(ctx context.Context, msg string, params map[string]string) {
+	logger.logger.Info(ctx, msg, withCorrelationIDParam(ctx, params))
+}
+
+func (logger correlatingLogger) Error(ctx context.Context, msg string, params map[string]string) {
+	logger.logger.Error(ctx, msg, withCorrelationIDParam(ctx, params))
+}
+
+// withCorrelationIDParam returns a copy of params with the correlation ID from ctx added, or params itself if ctx
+// doesn't have one
+func withCorrelationIDParam(ctx context.Context, params map[string]string) map[string]string {
+	correlationID := CorrelationIDFromContext(ctx)
+	if correlationID == "" {
+		return params
+	}
+
+	withCorrelationID := make(map[string]string, len(params)+1)
+	for key, value := range params {
+		withCorrelationID[key] = value
+	}
+	withCorrelationID["correlation_id"] = correlationID
+
+	return withCorrelationID
+}
@@ -0,0 +1,153 @@
+package verifiedsms
+
+import (
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	// gsm7SingleSegmentLength and gsm7MultiSegmentLength are the number of GSM-7 septets which fit in a single SMS and
+	// in each part of a concatenated SMS, which loses some space to the header joining the parts
+	gsm7SingleSegmentLength = 160
+	gsm7MultiSegmentLength  = 153
+
+	// ucs2SingleSegmentLength and ucs2MultiSegmentLength are the number of UCS-2 code units which fit in a single SMS
+	// and in each part of a concatenated SMS
+	ucs2SingleSegmentLength = 70
+	ucs2MultiSegmentLength  = 67
+)
+
+// gsm7Basic is the GSM 03.38 default alphabet, each character is sent as a single septet
+const gsm7Basic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+
+// gsm7Extension is the GSM 03.38 extension table, each character is sent as an escape septet followed by its own
+const gsm7Extension = "\f^{}\\[~]|€"
+
+var (
+	gsm7BasicRunes     = runeSet(gsm7Basic)
+	gsm7ExtensionRunes = runeSet(gsm7Extension)
+)
+
+func runeSet(characters string) map[rune]bool {
+	set := map[rune]bool{}
+	for _, character := range characters {
+		set[character] = true
+	}
+
+	return set
+}
+
+// ValidateMessageLength returns the number of SMS segments smsMessage will be sent as, encoding it as GSM-7 if every
+// character is in the GSM 03.38 alphabet and as UCS-2 otherwise
+// ErrMultipleSegments is returned along with the count if the message doesn't fit in a single SMS, as gateways which
+// split or reject long messages can deliver something other than what was verified
+func ValidateMessageLength(smsMessage string) (int, error) {
+	segments := countSegments(smsMessage)
+	if segments > 1 {
+		return segments, ErrMultipleSegments
+	}
+
+	return segments, nil
+}
+
+// countSegments returns the number of SMS segments smsMessage will be sent as
+func countSegments(smsMessage string) int {
+	septets, isGSM7 := gsm7Length(smsMessage)
+	if isGSM7 {
+		return segmentsFor(septets, gsm7SingleSegmentLength, gsm7MultiSegmentLength)
+	}
+
+	// UCS-2 can't encode characters outside the Basic Multilingual Plane, such as most emoji, so they're sent as UTF-16
+	// surrogate pairs taking two code units each
+	return segmentsFor(len(utf16.Encode([]rune(smsMessage))), ucs2SingleSegmentLength, ucs2MultiSegmentLength)
+}
+
+// gsm7Length returns the number of septets smsMessage takes up in GSM-7, and whether it can be encoded in GSM-7 at all
+func gsm7Length(smsMessage string) (int, bool) {
+	septets := 0
+
+	for _, character := range smsMessage {
+		switch {
+		case gsm7BasicRunes[character]:
+			septets++
+		case gsm7ExtensionRunes[character]:
+			septets += 2
+		default:
+			return 0, false
+		}
+	}
+
+	return septets, true
+}
+
+// segmentsFor returns the number of segments needed for length characters
+func segmentsFor(length int, singleSegmentLength int, multiSegmentLength int) int {
+	if length <= singleSegmentLength {
+		return 1
+	}
+
+	return (length + multiSegmentLength - 1) / multiSegmentLength
+}
+
+// splitSegments splits smsMessage into the parts it will be sent as, using the same encoding as countSegments
+// Characters are never split across parts, so GSM-7 extension characters and UTF-16 surrogate pairs stay whole
+func splitSegments(smsMessage string) []string {
+	if countSegments(smsMessage) == 1 {
+		return []string{smsMessage}
+	}
+
+	_, isGSM7 := gsm7Length(smsMessage)
+
+	var segments []string
+	var segment strings.Builder
+
+	length := 0
+
+	for _, character := range smsMessage {
+		characterLength := ucs2Length(character)
+		maxLength := ucs2MultiSegmentLength
+
+		if isGSM7 {
+			characterLength = 1
+			if gsm7ExtensionRunes[character] {
+				characterLength = 2
+			}
+			maxLength = gsm7MultiSegmentLength
+		}
+
+		if length+characterLength > maxLength {
+			segments = append(segments, segment.String())
+			segment.Reset()
+			length = 0
+		}
+
+		segment.WriteRune(character)
+		length += characterLength
+	}
+
+	return append(segments, segment.String())
+}
+
+// ucs2Length returns the number of UCS-2 code units character is sent as
+func ucs2Length(character rune) int {
+	if character > 0xffff {
+		return 2
+	}
+
+	return 1
+}
+
+// segmentVariants returns the forms a device might show a message which doesn't fit in a single SMS as, if its parts
+// aren't reassembled exactly: each part on its own, and the parts joined by a space or a line break
+// It returns nothing if smsMessage fits in a single SMS
+func segmentVariants(smsMessage string) []string {
+	segments := splitSegments(smsMessage)
+	if len(segments) < 2 {
+		return nil
+	}
+
+	variants := append([]string{}, segments...)
+
+	return append(variants, strings.Join(segments, " "), strings.Join(segments, "\n"))
+}
@@ -0,0 +1,60 @@
+package verifiedsms
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitSegmentsExactlyTwoGSM7Segments(t *testing.T) {
+	first := strings.Repeat("a", gsm7MultiSegmentLength)
+	second := strings.Repeat("b", gsm7MultiSegmentLength)
+
+	segments := splitSegments(first + second)
+
+	expected := []string{first, second}
+	if !reflect.DeepEqual(segments, expected) {
+		t.Errorf("expected 2 segments of %d characters, got %q", gsm7MultiSegmentLength, segments)
+	}
+
+	if segments, err := ValidateMessageLength(first + second); segments != 2 || err != ErrMultipleSegments {
+		t.Errorf("expected 2 segments and ErrMultipleSegments, got %d and %v", segments, err)
+	}
+
+	if segments := splitSegments(first + second + "c"); len(segments) != 3 {
+		t.Errorf("expected one more character to need a third segment, got %d", len(segments))
+	}
+}
+
+func TestSplitSegmentsSingleSegment(t *testing.T) {
+	smsMessage := strings.Repeat("a", gsm7SingleSegmentLength)
+
+	segments := splitSegments(smsMessage)
+	if !reflect.DeepEqual(segments, []string{smsMessage}) {
+		t.Errorf("expected a %d character message to be a single segment, got %q", gsm7SingleSegmentLength, segments)
+	}
+}
+
+func TestSplitSegmentsKeepsGSM7ExtensionCharactersWhole(t *testing.T) {
+	// The euro sign takes two septets, so it doesn't fit in the last septet of the first segment
+	smsMessage := strings.Repeat("a", gsm7MultiSegmentLength-1) + "€" + strings.Repeat("b", 10)
+
+	segments := splitSegments(smsMessage)
+
+	expected := []string{strings.Repeat("a", gsm7MultiSegmentLength-1), "€" + strings.Repeat("b", 10)}
+	if !reflect.DeepEqual(segments, expected) {
+		t.Errorf("expected %q, got %q", expected, segments)
+	}
+}
+
+func TestSegmentVariantsForTwoGSM7Segments(t *testing.T) {
+	first := strings.Repeat("a", gsm7MultiSegmentLength)
+	second := strings.Repeat("b", gsm7MultiSegmentLength)
+
+	variants := segmentVariants(first + second)
+
+	expected := []string{first, second, first + " " + second, first + "\n" + second}
+	if !reflect.DeepEqual(variants, expected) {
+		t.Errorf("expected each part and the parts joined, got %q", variants)
+	}
+}
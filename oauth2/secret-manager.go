@@ -0,0 +1,106 @@
+package oauth2
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/monzo/terrors"
+	xoauth2 "golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SecretManagerScope is the scope used to read secrets from Secret Manager
+	SecretManagerScope = "https://www.googleapis.com/auth/cloud-platform"
+
+	secretManagerBaseUrl = "https://secretmanager.googleapis.com/v1/"
+
+	// maxSecretBytes is the most we read from Secret Manager, which is far larger than any service account JSON
+	maxSecretBytes = 1 << 20
+)
+
+// GetServiceAccountJSONFromSecretManager reads a service account's JSON keys from the Secret Manager secret with the
+// given resource name, e.g. "projects/my-project/secrets/verified-sms/versions/latest". If the resource name doesn't
+// name a version the latest version is read
+// Secret Manager is accessed using Application Default Credentials
+func GetServiceAccountJSONFromSecretManager(ctx context.Context, resourceName string) ([]byte, error) {
+	if !strings.HasPrefix(resourceName, "projects/") || !strings.Contains(resourceName, "/secrets/") {
+		return nil, terrors.BadRequest(
+			terrors.ErrBadRequest,
+			"Secret Manager resource name should look like projects/{project}/secrets/{secret}",
+			map[string]string{
+				"resource_name": resourceName,
+			},
+		)
+	}
+
+	if !strings.Contains(resourceName, "/versions/") {
+		resourceName += "/versions/latest"
+	}
+
+	ctx = context.WithValue(ctx, xoauth2.HTTPClient, DefaultClient(Timeouts{}))
+
+	client, err := google.DefaultClient(ctx, SecretManagerScope)
+	if err != nil {
+		return nil, terrors.Augment(err, "failed to find Application Default Credentials for Secret Manager", nil)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, secretManagerBaseUrl+resourceName+":access", nil)
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, terrors.Augment(err, "failed to access secret in Secret Manager", map[string]string{
+			"resource_name": resourceName,
+		})
+	}
+	defer response.Body.Close()
+
+	params := map[string]string{
+		"resource_name": resourceName,
+		"http_status":   strconv.Itoa(response.StatusCode),
+	}
+
+	switch {
+	case response.StatusCode == http.StatusNotFound:
+		return nil, terrors.NotFound(terrors.ErrNotFound, "secret does not exist in Secret Manager", params)
+	case response.StatusCode == http.StatusForbidden:
+		return nil, terrors.Forbidden(
+			terrors.ErrForbidden,
+			"access to the secret in Secret Manager was denied, check the Application Default Credentials have the "+
+				"Secret Manager Secret Accessor role",
+			params,
+		)
+	case response.StatusCode < 200 || response.StatusCode > 299:
+		return nil, terrors.InternalService(terrors.ErrInternalService, "bad response from Secret Manager: "+response.Status, params)
+	}
+
+	secretResponse := accessSecretVersionResponse{}
+	err = json.NewDecoder(io.LimitReader(response.Body, maxSecretBytes)).Decode(&secretResponse)
+	if err != nil {
+		return nil, terrors.Augment(err, "failed to decode response from Secret Manager", params)
+	}
+
+	serviceAccountJSON, err := base64.StdEncoding.DecodeString(secretResponse.Payload.Data)
+	if err != nil {
+		return nil, terrors.Augment(err, "failed to decode secret from Secret Manager", params)
+	}
+
+	if len(serviceAccountJSON) == 0 {
+		return nil, terrors.NotFound(terrors.ErrNotFound, "secret in Secret Manager is empty", params)
+	}
+
+	return serviceAccountJSON, nil
+}
+
+type accessSecretVersionResponse struct {
+	Payload struct {
+		Data string `json:"data"`
+	} `json:"payload"`
+}
@@ -0,0 +1,17 @@
+package verifiedsms
+
+import (
+	"time"
+)
+
+// Observer is notified of the outcome of verifications and of every call made to Google, so metrics can be recorded
+// without this package depending on any particular metrics library
+// Implementations must be safe for concurrent use
+type Observer interface {
+	// ObserveVerification is called once MarkSMSAsVerified has finished, result is nil if err is not
+	ObserveVerification(result *VerificationResult, duration time.Duration, err error)
+
+	// ObserveAPICall is called after each request to Google, endpoint is the API path which was called and statusCode
+	// is zero if no response was received
+	ObserveAPICall(endpoint string, statusCode int, duration time.Duration, err error)
+}
@@ -0,0 +1,57 @@
+// Package opentelemetry traces a verifiedsms.Partner with OpenTelemetry, it is kept separate so the OpenTelemetry
+// packages are only built by callers who use them
+package opentelemetry
+
+import (
+	"context"
+	"github.com/monzo/verifiedsms"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName identifies the spans started by the Tracer
+const InstrumentationName = "github.com/monzo/verifiedsms"
+
+// Tracer is a verifiedsms.Tracer which starts OpenTelemetry spans
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// NewTracer returns a Tracer which starts spans with a tracer from provider, e.g. otel.GetTracerProvider()
+func NewTracer(provider trace.TracerProvider) *Tracer {
+	return &Tracer{
+		tracer: provider.Tracer(InstrumentationName),
+	}
+}
+
+// StartSpan starts a span called name as a child of any span in ctx
+func (tracer *Tracer) StartSpan(ctx context.Context, name string) (context.Context, verifiedsms.Span) {
+	ctx, span := tracer.tracer.Start(ctx, name)
+
+	return ctx, otelSpan{
+		span: span,
+	}
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (span otelSpan) SetAttributes(attributes map[string]string) {
+	keyValues := make([]attribute.KeyValue, 0, len(attributes))
+	for key, value := range attributes {
+		keyValues = append(keyValues, attribute.String(key, value))
+	}
+
+	span.span.SetAttributes(keyValues...)
+}
+
+func (span otelSpan) End(err error) {
+	if err != nil {
+		span.span.RecordError(err)
+		span.span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.span.End()
+}
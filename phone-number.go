@@ -0,0 +1,117 @@
+package verifiedsms
+
+import (
+	"github.com/monzo/terrors"
+	"github.com/nyaruka/phonenumbers"
+	"strings"
+)
+
+// maxE164Digits is the maximum number of digits, including the country code, in an E.164 phone number
+const maxE164Digits = 15
+
+// ValidatePhoneNumber returns a terrors.BadRequest if phoneNumber isn't in E.164 form, i.e. a + followed by a country
+// code which doesn't start with zero and at most 15 digits in total, with no spaces, punctuation or extension
+func ValidatePhoneNumber(phoneNumber string) error {
+	if !strings.HasPrefix(phoneNumber, "+") {
+		return invalidPhoneNumber("phone number must start with +")
+	}
+
+	digits := strings.TrimPrefix(phoneNumber, "+")
+
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			if r == 'x' || r == 'X' || r == ';' || r == ',' {
+				return invalidPhoneNumber("phone number must not have an extension")
+			}
+
+			return invalidPhoneNumber("phone number must only contain digits after the +")
+		}
+	}
+
+	switch {
+	case len(digits) < 2:
+		return invalidPhoneNumber("phone number is too short")
+	case len(digits) > maxE164Digits:
+		return invalidPhoneNumber("phone number must not be longer than 15 digits")
+	case digits[0] == '0':
+		return invalidPhoneNumber("phone number country code must not start with 0")
+	}
+
+	return nil
+}
+
+// NormalizeNumber converts a phone number in national or international format to E.164, region is the ISO 3166-1
+// two letter code, e.g. "GB", of the region national numbers are dialled from. Numbers already in E.164 are returned
+// unchanged
+func (partner Partner) NormalizeNumber(rawPhoneNumber string, region string) (string, error) {
+	if ValidatePhoneNumber(rawPhoneNumber) == nil {
+		return rawPhoneNumber, nil
+	}
+
+	parsed, err := phonenumbers.Parse(rawPhoneNumber, strings.ToUpper(region))
+	if err != nil {
+		return "", terrors.BadRequest(ErrInvalidArgument, "phone number could not be parsed", map[string]string{
+			"region": region,
+			"error":  err.Error(),
+		})
+	}
+
+	if !phonenumbers.IsPossibleNumber(parsed) {
+		return "", terrors.BadRequest(ErrInvalidArgument, "phone number is not possible in its region", map[string]string{
+			"region": region,
+		})
+	}
+
+	return phonenumbers.Format(parsed, phonenumbers.E164), nil
+}
+
+// resolvePhoneNumber normalises phoneNumber with the Partner's DefaultRegion if it has one, and checks the result is
+// in E.164 form
+func (partner Partner) resolvePhoneNumber(phoneNumber string) (string, error) {
+	if partner.DefaultRegion != "" {
+		normalized, err := partner.NormalizeNumber(phoneNumber, partner.DefaultRegion)
+		if err != nil {
+			return "", terrors.Propagate(err)
+		}
+
+		phoneNumber = normalized
+	}
+
+	err := ValidatePhoneNumber(phoneNumber)
+	if err != nil {
+		return "", terrors.Propagate(err)
+	}
+
+	return phoneNumber, nil
+}
+
+func invalidPhoneNumber(reason string) error {
+	return terrors.BadRequest(ErrInvalidArgument, "phone number is not in E.164 format: "+reason, nil)
+}
+
+// normalizePhoneNumber returns phoneNumber in E.164 form so numbers which are formatted differently, e.g. with spaces or
+// an international 00 prefix, can be compared
+func normalizePhoneNumber(phoneNumber string) string {
+	var normalized strings.Builder
+
+	for _, r := range strings.TrimSpace(phoneNumber) {
+		switch {
+		case r >= '0' && r <= '9':
+			normalized.WriteRune(r)
+		case r == '+' && normalized.Len() == 0:
+			normalized.WriteRune(r)
+		}
+	}
+
+	digits := normalized.String()
+
+	if strings.HasPrefix(digits, "00") {
+		return "+" + strings.TrimPrefix(digits, "00")
+	}
+
+	if !strings.HasPrefix(digits, "+") {
+		return "+" + digits
+	}
+
+	return digits
+}
@@ -0,0 +1,48 @@
+package verifiedsms
+
+import (
+	"context"
+	"github.com/monzo/terrors"
+	"github.com/monzo/verifiedsms/oauth2"
+	"net/http"
+	"strings"
+)
+
+// pingPhoneNumber is reserved by Ofcom for use in drama, so it can never have Verified SMS public keys registered to it
+const pingPhoneNumber = "+447700900000"
+
+// Ping checks the Partner can authenticate with and reach the Verified SMS service by looking up the public keys for a
+// phone number which can never be registered, so nothing is submitted to Google
+// If Google reports which scopes the Partner's token was granted, an error is returned without any request to the
+// Verified SMS service when they don't include the Verified SMS scope
+// It isn't retried and bypasses the PublicKeyCache so it fails fast and always reflects the current state, which makes
+// it suitable for readiness checks
+func (partner Partner) Ping(ctx context.Context) error {
+	partner.RetryPolicy = nil
+
+	token, err := partner.getToken()
+	if err != nil {
+		return terrors.Augment(err, "failed to ping the Verified SMS service", nil)
+	}
+
+	// Google only says which scopes a token was granted for some credentials, when it does a missing scope is reported
+	// here rather than as a permission error from the request
+	if !oauth2.HasScope(token) {
+		scopes, _ := oauth2.GrantedScopes(token)
+		return terrors.Forbidden(terrors.ErrForbidden, "the Partner's token wasn't granted the "+oauth2.Scope+" scope", map[string]string{
+			"required_scope": oauth2.Scope,
+			"granted_scopes": strings.Join(scopes, " "),
+		})
+	}
+
+	_, err = partner.doRequest(ctx, http.MethodPost, apiGetPublicKeysPath, map[string][]string{
+		"phoneNumbers": {
+			pingPhoneNumber,
+		},
+	}, nil)
+	if err != nil {
+		return terrors.Augment(err, "failed to ping the Verified SMS service", nil)
+	}
+
+	return nil
+}
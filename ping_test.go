@@ -0,0 +1,91 @@
+package verifiedsms
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/monzo/verifiedsms/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// newFakeTokenEndpoint returns the URL of a token endpoint which grants tokens for scope
+func newFakeTokenEndpoint(t *testing.T, scope string) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"scope":        scope,
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
+
+// newScopedTestPartner returns a Partner which sends its requests to handler, authenticated with a token for scope
+// from a fake token endpoint
+func newScopedTestPartner(t *testing.T, scope string, handler http.Handler) Partner {
+	t.Helper()
+
+	config := clientcredentials.Config{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		TokenURL:     newFakeTokenEndpoint(t, scope),
+	}
+
+	partner := newTestPartner(t, handler)
+	partner.TokenSource = config.TokenSource(context.Background())
+
+	return partner
+}
+
+func TestPingWithoutTheScopeDoesntCallGoogle(t *testing.T) {
+	const grantedScope = "https://www.googleapis.com/auth/cloud-platform"
+
+	partner := newScopedTestPartner(t, grantedScope, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("expected no request to Google, got %s", r.URL.Path)
+	}))
+
+	err := partner.Ping(context.Background())
+	if !terrors.Is(err, terrors.ErrForbidden) {
+		t.Fatalf("expected a forbidden error, got %v", err)
+	}
+
+	if !strings.Contains(err.Error(), oauth2.Scope) {
+		t.Errorf("expected the error to name the required scope, got %q", err.Error())
+	}
+
+	params := err.(*terrors.Error).Params
+	if params["required_scope"] != oauth2.Scope {
+		t.Errorf("expected the required scope, got %q", params["required_scope"])
+	}
+
+	if params["granted_scopes"] != grantedScope {
+		t.Errorf("expected the granted scopes, got %q", params["granted_scopes"])
+	}
+}
+
+func TestPingWithTheScopeCallsGoogle(t *testing.T) {
+	requests := 0
+	partner := newScopedTestPartner(t, oauth2.Scope, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write([]byte(`{}`))
+	}))
+
+	if err := partner.Ping(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if requests != 1 {
+		t.Errorf("expected 1 request to Google, got %d", requests)
+	}
+}
@@ -0,0 +1,117 @@
+package verifiedsms
+
+import (
+	"sync"
+	"time"
+)
+
+// PublicKeyCache caches the public keys registered to phone numbers so repeated sends to the same user don't need to
+// fetch them from Google every time. Phone numbers with no public keys are cached too, so users who aren't on
+// Verified SMS aren't looked up repeatedly either
+// It is safe for concurrent use
+type PublicKeyCache struct {
+	ttl     time.Duration
+	maxSize int
+	clock   clock
+
+	mu      sync.Mutex
+	entries map[string]publicKeyCacheEntry
+}
+
+type publicKeyCacheEntry struct {
+	publicKeys []string
+	expiresAt  time.Time
+}
+
+// NewPublicKeyCache returns a PublicKeyCache which caches public keys for ttl and holds keys for at most maxSize phone
+// numbers, if maxSize is zero the size of the cache isn't limited
+func NewPublicKeyCache(ttl time.Duration, maxSize int) *PublicKeyCache {
+	return newPublicKeyCache(ttl, maxSize, realClock{})
+}
+
+// newPublicKeyCache returns a PublicKeyCache which tells the time with clock
+func newPublicKeyCache(ttl time.Duration, maxSize int, clock clock) *PublicKeyCache {
+	return &PublicKeyCache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		clock:   clock,
+		entries: map[string]publicKeyCacheEntry{},
+	}
+}
+
+// Get returns the cached public keys for phoneNumber and whether they were found in the cache and still fresh
+func (cache *PublicKeyCache) Get(phoneNumber string) ([]string, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	entry, ok := cache.entries[phoneNumber]
+	if !ok {
+		return nil, false
+	}
+
+	if !cache.clock.Now().Before(entry.expiresAt) {
+		delete(cache.entries, phoneNumber)
+		return nil, false
+	}
+
+	return entry.publicKeys, true
+}
+
+// Set caches publicKeys for phoneNumber, evicting the entry closest to expiry if the cache is full
+func (cache *PublicKeyCache) Set(phoneNumber string, publicKeys []string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	now := cache.clock.Now()
+
+	if _, ok := cache.entries[phoneNumber]; !ok && cache.maxSize > 0 && len(cache.entries) >= cache.maxSize {
+		cache.evict(now)
+	}
+
+	cache.entries[phoneNumber] = publicKeyCacheEntry{
+		publicKeys: publicKeys,
+		expiresAt:  now.Add(cache.ttl),
+	}
+}
+
+// Invalidate removes any cached public keys for phoneNumber, this should be called when a user re-registers their
+// device so their new keys are fetched on the next send
+func (cache *PublicKeyCache) Invalidate(phoneNumber string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	delete(cache.entries, phoneNumber)
+}
+
+// Clear removes every cached public key
+func (cache *PublicKeyCache) Clear() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.entries = map[string]publicKeyCacheEntry{}
+}
+
+// evict removes every expired entry or, if none have expired, the entry closest to expiry
+// The caller must hold cache.mu
+func (cache *PublicKeyCache) evict(now time.Time) {
+	var oldestPhoneNumber string
+	var oldestExpiresAt time.Time
+	evicted := false
+
+	for phoneNumber, entry := range cache.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(cache.entries, phoneNumber)
+			evicted = true
+			continue
+		}
+
+		if oldestPhoneNumber == "" || entry.expiresAt.Before(oldestExpiresAt) {
+			oldestPhoneNumber = phoneNumber
+			oldestExpiresAt = entry.expiresAt
+		}
+	}
+
+	if !evicted && oldestPhoneNumber != "" {
+		delete(cache.entries, oldestPhoneNumber)
+	}
+}
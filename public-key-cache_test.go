@@ -0,0 +1,44 @@
+package verifiedsms
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPublicKeyCacheExpiresAfterTTL(t *testing.T) {
+	clock := newFakeClock()
+	cache := newPublicKeyCache(time.Minute, 0, clock)
+
+	cache.Set("+447700900001", []string{"key"})
+
+	clock.Advance(59 * time.Second)
+	if publicKeys, ok := cache.Get("+447700900001"); !ok || len(publicKeys) != 1 {
+		t.Fatalf("expected the keys to be cached before the TTL, got %v, %v", publicKeys, ok)
+	}
+
+	clock.Advance(time.Second)
+	if publicKeys, ok := cache.Get("+447700900001"); ok {
+		t.Errorf("expected the keys to expire after the TTL, got %v", publicKeys)
+	}
+}
+
+func TestPublicKeyCacheEvictsClosestToExpiry(t *testing.T) {
+	clock := newFakeClock()
+	cache := newPublicKeyCache(time.Minute, 2, clock)
+
+	cache.Set("+447700900001", nil)
+	clock.Advance(time.Second)
+	cache.Set("+447700900002", nil)
+	clock.Advance(time.Second)
+	cache.Set("+447700900003", nil)
+
+	if _, ok := cache.Get("+447700900001"); ok {
+		t.Errorf("expected the oldest entry to be evicted")
+	}
+
+	for _, phoneNumber := range []string{"+447700900002", "+447700900003"} {
+		if _, ok := cache.Get(phoneNumber); !ok {
+			t.Errorf("expected %s to still be cached", phoneNumber)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package verifiedsms
+
+import (
+	"context"
+	"github.com/monzo/terrors"
+	"sync"
+	"time"
+)
+
+// PublicKeyCoalescer batches public key lookups which arrive within a short window of each other into a single
+// request to Google, then gives each caller the keys for the phone numbers it asked for. This cuts the number of
+// requests made when many messages are sent at once
+// Every caller in a batch gets the same error if the request fails, and a caller whose context is done stops waiting
+// without affecting the rest of the batch
+// It is safe for concurrent use
+type PublicKeyCoalescer struct {
+	window       time.Duration
+	maxBatchSize int
+	clock        clock
+
+	mu      sync.Mutex
+	pending *coalescedLookup
+}
+
+// coalescedLookup is a batch of phone numbers whose public keys will be fetched in a single request
+type coalescedLookup struct {
+	phoneNumbers []string
+	requested    map[string]bool
+
+	// waiters is the number of callers still waiting for the result, the request is cancelled if every caller gives up
+	waiters int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// full is closed to send the batch early once it reaches the maximum batch size
+	full chan struct{}
+
+	// done is closed once publicKeys and err are set
+	done       chan struct{}
+	publicKeys map[string][]string
+	err        error
+}
+
+// NewPublicKeyCoalescer returns a PublicKeyCoalescer which waits window after the first lookup in a batch before
+// sending it, and sends batches early once they hold maxBatchSize phone numbers. If maxBatchSize is zero
+// DefaultMaxBatchSize is used
+func NewPublicKeyCoalescer(window time.Duration, maxBatchSize int) *PublicKeyCoalescer {
+	return newPublicKeyCoalescer(window, maxBatchSize, realClock{})
+}
+
+// newPublicKeyCoalescer returns a PublicKeyCoalescer which tells the time with clock
+func newPublicKeyCoalescer(window time.Duration, maxBatchSize int, clock clock) *PublicKeyCoalescer {
+	if maxBatchSize < 1 {
+		maxBatchSize = DefaultMaxBatchSize
+	}
+
+	return &PublicKeyCoalescer{
+		window:       window,
+		maxBatchSize: maxBatchSize,
+		clock:        clock,
+	}
+}
+
+// lookup adds phoneNumbers to the pending batch, starting a new one with fetch if needed, and waits for their public
+// keys
+func (coalescer *PublicKeyCoalescer) lookup(ctx context.Context, phoneNumbers []string, fetch func(context.Context, []string) (map[string][]string, error)) (map[string][]string, error) {
+	batch := coalescer.join(phoneNumbers, fetch)
+
+	select {
+	case <-batch.done:
+	case <-ctx.Done():
+		coalescer.leave(batch)
+		return nil, terrors.Timeout(terrors.ErrTimeout, "context done while waiting for public keys", map[string]string{
+			"error": ctx.Err().Error(),
+		})
+	}
+
+	if batch.err != nil {
+		return nil, terrors.Propagate(batch.err)
+	}
+
+	publicKeysByNumber := make(map[string][]string, len(phoneNumbers))
+	for _, phoneNumber := range phoneNumbers {
+		if publicKeys, ok := batch.publicKeys[phoneNumber]; ok {
+			publicKeysByNumber[phoneNumber] = publicKeys
+		}
+	}
+
+	return publicKeysByNumber, nil
+}
+
+// join adds phoneNumbers to the pending batch and returns it, a new batch is started if there isn't one pending or the
+// pending one doesn't have room
+func (coalescer *PublicKeyCoalescer) join(phoneNumbers []string, fetch func(context.Context, []string) (map[string][]string, error)) *coalescedLookup {
+	coalescer.mu.Lock()
+	defer coalescer.mu.Unlock()
+
+	batch := coalescer.pending
+	if batch == nil || len(batch.phoneNumbers)+len(phoneNumbers) > coalescer.maxBatchSize {
+		if batch != nil {
+			close(batch.full)
+		}
+
+		batch = coalescer.newBatch(fetch)
+		coalescer.pending = batch
+	}
+
+	for _, phoneNumber := range phoneNumbers {
+		if batch.requested[phoneNumber] {
+			continue
+		}
+
+		batch.requested[phoneNumber] = true
+		batch.phoneNumbers = append(batch.phoneNumbers, phoneNumber)
+	}
+
+	batch.waiters++
+
+	if len(batch.phoneNumbers) >= coalescer.maxBatchSize {
+		close(batch.full)
+		coalescer.pending = nil
+	}
+
+	return batch
+}
+
+// leave stops a caller waiting for batch, cancelling its request if nobody else is waiting
+func (coalescer *PublicKeyCoalescer) leave(batch *coalescedLookup) {
+	coalescer.mu.Lock()
+	defer coalescer.mu.Unlock()
+
+	batch.waiters--
+	if batch.waiters > 0 {
+		return
+	}
+
+	if coalescer.pending == batch {
+		coalescer.pending = nil
+	}
+
+	batch.cancel()
+}
+
+// newBatch starts a batch which is sent with fetch once the window has passed or it's full
+// The caller must hold coalescer.mu
+func (coalescer *PublicKeyCoalescer) newBatch(fetch func(context.Context, []string) (map[string][]string, error)) *coalescedLookup {
+	// The request is shared by every caller in the batch, so it isn't bound to any one caller's context
+	ctx, cancel := context.WithCancel(context.Background())
+
+	batch := &coalescedLookup{
+		requested: map[string]bool{},
+		ctx:       ctx,
+		cancel:    cancel,
+		full:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	go func() {
+		select {
+		case <-coalescer.clock.After(coalescer.window):
+		case <-batch.full:
+		case <-ctx.Done():
+		}
+
+		coalescer.mu.Lock()
+		if coalescer.pending == batch {
+			coalescer.pending = nil
+		}
+		phoneNumbers := batch.phoneNumbers
+		coalescer.mu.Unlock()
+
+		if ctx.Err() != nil {
+			batch.err = terrors.Timeout(terrors.ErrTimeout, "every caller stopped waiting for public keys", nil)
+		} else {
+			batch.publicKeys, batch.err = fetch(ctx, phoneNumbers)
+		}
+
+		cancel()
+		close(batch.done)
+	}()
+
+	return batch
+}
@@ -0,0 +1,74 @@
+package verifiedsms
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/monzo/terrors"
+	"net/http"
+)
+
+// DoRawRequest sends requestStruct as JSON to the given path of the Verified SMS API, e.g. "/v1/messages:batchCreate",
+// authenticated as the Partner and returns the undecoded response body, so fields this package doesn't model can be
+// read. requestStruct may be nil if the request has no body
+// Retries, logging, metrics and error handling are the same as for every other request made by the Partner
+func (partner Partner) DoRawRequest(ctx context.Context, method string, path string, requestStruct interface{}) (json.RawMessage, error) {
+	var response json.RawMessage
+
+	_, err := partner.doRequest(ctx, method, path, requestStruct, &response)
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
+
+	return response, nil
+}
+
+// GetPhoneNumbersPublicKeysRaw gets the public keys for all of the given phone numbers from the Verified SMS service
+// and returns the undecoded response body. Unlike GetPhoneNumbersPublicKeys the PublicKeyCache isn't used
+func (partner Partner) GetPhoneNumbersPublicKeysRaw(ctx context.Context, phoneNumbers []string) (json.RawMessage, error) {
+	for _, phoneNumber := range phoneNumbers {
+		err := ValidatePhoneNumber(phoneNumber)
+		if err != nil {
+			return nil, terrors.Propagate(err)
+		}
+	}
+
+	return partner.DoRawRequest(ctx, http.MethodPost, apiGetPublicKeysPath, map[string][]string{
+		"phoneNumbers": phoneNumbers,
+	})
+}
+
+// ComputeSubmissionBodies returns the exact JSON request bodies MarkSMSAsVerified would send to Google to submit the
+// hashes for a given SMS sent to a given end users phone number, one for each chunk of at most the Partner's
+// MaxBatchSize hashes, without submitting them. This is useful for reproducing and diffing production payloads
+// Bodies are returned before any compression. The slice will be empty if the users' device doesn't support Verified
+// SMS
+func (partner Partner) ComputeSubmissionBodies(ctx context.Context, phoneNumber string, agent *Agent, smsMessage string) ([][]byte, error) {
+	err := agent.validate(partner.HashingOptions)
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
+
+	publicKeys, err := partner.GetPhoneNumberPublicKeys(ctx, phoneNumber)
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
+
+	messagesToGoogle, _, err := partner.getMessagesToGoogle(publicKeys, agent, smsMessage)
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
+
+	requests := partner.submitRequests(dedupeMessages(messagesToGoogle))
+
+	bodies := make([][]byte, 0, len(requests))
+	for _, requestStruct := range requests {
+		body, err := marshalRequestBody(requestStruct)
+		if err != nil {
+			return nil, terrors.Propagate(err)
+		}
+
+		bodies = append(bodies, body)
+	}
+
+	return bodies, nil
+}
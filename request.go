@@ -0,0 +1,388 @@
+package verifiedsms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/monzo/terrors"
+	"github.com/monzo/verifiedsms/oauth2"
+	xoauth2 "golang.org/x/oauth2"
+	"golang.org/x/time/rate"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// requestIDHeaders are the response headers Google may use to identify a request, which they need when escalating a
+// support ticket
+var requestIDHeaders = []string{
+	"X-Request-Id",
+	"X-Google-Request-Id",
+}
+
+// responseMetadata describes a response from Google
+type responseMetadata struct {
+	// StatusCode is zero if no response was received
+	StatusCode int
+
+	// RequestID is Google's identifier for the request, it is empty if Google didn't return one
+	RequestID string
+}
+
+// doRequest sends requestStruct as JSON to the API path as the Partner and decodes the JSON response into
+// responseStruct, requestStruct may be nil if the request has no body and responseStruct may be nil if the response
+// body isn't needed
+func (partner Partner) doRequest(ctx context.Context, method string, path string, requestStruct interface{}, responseStruct interface{}) (responseMetadata, error) {
+	ctx, span := partner.startSpan(ctx, "verifiedsms.APICall")
+
+	start := time.Now()
+	metadata, err := partner.performRequest(ctx, method, path, requestStruct, responseStruct)
+
+	span.SetAttributes(map[string]string{
+		"verifiedsms.endpoint":       path,
+		"http.method":                method,
+		"http.status_code":           strconv.Itoa(metadata.StatusCode),
+		"verifiedsms.request_id":     metadata.RequestID,
+		"verifiedsms.correlation_id": CorrelationIDFromContext(ctx),
+	})
+	span.End(err)
+
+	if partner.Observer != nil {
+		partner.Observer.ObserveAPICall(path, metadata.StatusCode, time.Since(start), err)
+	}
+
+	return metadata, err
+}
+
+// performRequest performs the request for doRequest
+func (partner Partner) performRequest(ctx context.Context, method string, path string, requestStruct interface{}, responseStruct interface{}) (responseMetadata, error) {
+	if partner.closed {
+		return responseMetadata{}, ErrPartnerClosed
+	}
+
+	var requestBody []byte
+	var contentEncoding string
+
+	if requestStruct != nil {
+		var err error
+		requestBody, err = marshalRequestBody(requestStruct)
+		if err != nil {
+			return responseMetadata{}, terrors.Propagate(err)
+		}
+
+		requestBody, contentEncoding, err = partner.encodeRequestBody(requestBody)
+		if err != nil {
+			return responseMetadata{}, terrors.Propagate(err)
+		}
+	}
+
+	client, err := partner.getHttpClient(ctx)
+	if err != nil {
+		return responseMetadata{}, terrors.Propagate(err)
+	}
+
+	url := partner.url(path)
+
+	partner.logger().Debug(ctx, "sending request to Google", map[string]string{
+		"url": url,
+	})
+
+	httpResponse, err := partner.sendRequest(ctx, client, method, url, requestBody, contentEncoding)
+	if err == nil && httpResponse.StatusCode == http.StatusUnauthorized {
+		// The token may have been revoked or expired early, so a new one is fetched and the request is tried once more
+		httpResponse.Body.Close()
+
+		partner.logger().Info(ctx, "Google rejected the token, refreshing it and retrying", map[string]string{
+			"url": url,
+		})
+
+		client, err = partner.refreshHttpClient(ctx)
+		if err != nil {
+			return responseMetadata{}, terrors.Propagate(err)
+		}
+
+		httpResponse, err = partner.sendRequest(ctx, client, method, url, requestBody, contentEncoding)
+	}
+	if err != nil {
+		partner.logger().Error(ctx, "request to Google failed", map[string]string{
+			"url":   url,
+			"error": err.Error(),
+		})
+		return responseMetadata{}, terrors.Propagate(err)
+	}
+	defer httpResponse.Body.Close()
+
+	metadata := responseMetadata{
+		StatusCode: httpResponse.StatusCode,
+		RequestID:  getRequestID(httpResponse),
+	}
+
+	err = decodeResponseBody(httpResponse)
+	if err != nil {
+		partner.logger().Error(ctx, "failed to decode response from Google", map[string]string{
+			"url":         url,
+			"status_code": strconv.Itoa(httpResponse.StatusCode),
+			"error":       err.Error(),
+		})
+		return metadata, terrors.Augment(err, "failed to decode response from Google", map[string]string{
+			"http_status": strconv.Itoa(httpResponse.StatusCode),
+		})
+	}
+
+	// The limit applies to the decompressed body, so a small compressed response can't expand without bound
+	httpResponse.Body = newLimitedBody(httpResponse.Body, partner.maxResponseBytes())
+
+	partner.logger().Debug(ctx, "received response from Google", map[string]string{
+		"url":         url,
+		"status_code": strconv.Itoa(httpResponse.StatusCode),
+		"request_id":  metadata.RequestID,
+	})
+
+	if httpResponse.StatusCode < 200 || httpResponse.StatusCode > 299 {
+		err := errorFromResponse(httpResponse, metadata.RequestID)
+		partner.logger().Error(ctx, "bad response from Google", map[string]string{
+			"url":         url,
+			"status_code": strconv.Itoa(httpResponse.StatusCode),
+			"error":       err.Error(),
+		})
+		return metadata, err
+	}
+
+	if responseStruct == nil {
+		return metadata, nil
+	}
+
+	// An empty body is treated as an empty response rather than an error
+	err = json.NewDecoder(httpResponse.Body).Decode(responseStruct)
+	if err != nil && err != io.EOF {
+		return metadata, terrors.Propagate(err)
+	}
+
+	return metadata, nil
+}
+
+// marshalRequestBody encodes requestStruct as the compact JSON sent to Google, without any whitespace between tokens
+func marshalRequestBody(requestStruct interface{}) ([]byte, error) {
+	requestBody, err := json.Marshal(requestStruct)
+	if err != nil {
+		return nil, terrors.Augment(err, "failed to marshal request body", nil)
+	}
+
+	return requestBody, nil
+}
+
+// getRequestID returns Google's identifier for the request from the response headers
+func getRequestID(httpResponse *http.Response) string {
+	for _, header := range requestIDHeaders {
+		if requestID := httpResponse.Header.Get(header); requestID != "" {
+			return requestID
+		}
+	}
+
+	return ""
+}
+
+// sendRequest sends requestBody to url, retrying according to the Partner's RetryPolicy. contentEncoding is the
+// Content-Encoding of requestBody, empty if it isn't compressed
+// The returned response is from the final attempt, and its body must be closed by the caller
+func (partner Partner) sendRequest(ctx context.Context, client *http.Client, method string, url string, requestBody []byte, contentEncoding string) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		if partner.RateLimiter != nil {
+			err := partner.RateLimiter.Wait(ctx)
+			if err != nil {
+				return nil, rateLimiterError(ctx, partner.RateLimiter, err)
+			}
+		}
+
+		var body io.Reader
+		if requestBody != nil {
+			body = bytes.NewReader(requestBody)
+		}
+
+		request, err := http.NewRequestWithContext(ctx, method, url, body)
+		if err != nil {
+			return nil, terrors.Propagate(err)
+		}
+
+		if requestBody != nil {
+			request.Header.Set("Content-Type", ContentTypeHeader)
+		}
+		if contentEncoding != "" {
+			request.Header.Set("Content-Encoding", contentEncoding)
+		}
+		if correlationID := CorrelationIDFromContext(ctx); correlationID != "" {
+			request.Header.Set(CorrelationIDHeader, correlationID)
+		}
+		request.Header.Set("User-Agent", partner.userAgent())
+		// Asking for gzip explicitly means responses are always decompressed by decodeResponseBody, whatever transport
+		// is used
+		request.Header.Set("Accept-Encoding", gzipContentEncoding)
+
+		httpResponse, err := client.Do(request)
+
+		if !partner.RetryPolicy.shouldRetry(ctx, attempt, httpResponse, err) {
+			if err != nil {
+				return nil, terrors.Propagate(err)
+			}
+
+			return httpResponse, nil
+		}
+
+		delay := partner.RetryPolicy.delay(partner.getClock(), attempt, httpResponse)
+
+		if !wait(ctx, partner.getClock(), delay) {
+			if err != nil {
+				return nil, terrors.Propagate(err)
+			}
+
+			return httpResponse, nil
+		}
+
+		if httpResponse != nil {
+			httpResponse.Body.Close()
+		}
+	}
+}
+
+// rateLimiterError describes why waiting for limiter failed. Only a request the limiter can never allow is rate
+// limited, a context which is done or whose deadline would pass first isn't worth retrying later
+// The limiter doesn't export its errors, so the failure is worked out from the context and the limiter's settings
+// rather than the error's text
+func rateLimiterError(ctx context.Context, limiter *rate.Limiter, err error) error {
+	if ctx.Err() != nil {
+		return terrors.Augment(ctx.Err(), "context done while waiting for the rate limiter", nil)
+	}
+
+	// A limiter with no burst can never allow a request, however long it waits
+	if limiter.Burst() < 1 && limiter.Limit() != rate.Inf {
+		return terrors.RateLimited(terrors.ErrRateLimited, "failed to wait for the rate limiter", map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	// Otherwise the limiter fails straight away if it couldn't allow the request before the context's deadline
+	if _, ok := ctx.Deadline(); ok {
+		return terrors.Timeout(terrors.ErrTimeout, "context deadline would pass while waiting for the rate limiter", map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return terrors.RateLimited(terrors.ErrRateLimited, "failed to wait for the rate limiter", map[string]string{
+		"error": err.Error(),
+	})
+}
+
+// maxResponseBytes returns the largest response body the Partner will read from Google
+func (partner Partner) maxResponseBytes() int64 {
+	if partner.MaxResponseBytes <= 0 {
+		return DefaultMaxResponseBytes
+	}
+
+	return partner.MaxResponseBytes
+}
+
+// limitedBody wraps a response body, failing reads once more than limit bytes have been read so a malformed or hostile
+// response can't make us allocate unbounded memory while decoding it
+type limitedBody struct {
+	io.Closer
+
+	reader io.Reader
+	limit  int64
+	read   int64
+}
+
+func newLimitedBody(body io.ReadCloser, limit int64) *limitedBody {
+	return &limitedBody{
+		Closer: body,
+		reader: io.LimitReader(body, limit+1),
+		limit:  limit,
+	}
+}
+
+func (body *limitedBody) Read(p []byte) (int, error) {
+	n, err := body.reader.Read(p)
+	body.read += int64(n)
+
+	if body.read > body.limit {
+		return 0, terrors.BadResponse(
+			terrors.ErrBadResponse,
+			"response from Google exceeded the maximum size",
+			map[string]string{
+				"max_response_bytes": strconv.FormatInt(body.limit, 10),
+			},
+		)
+	}
+
+	return n, err
+}
+
+// userAgent returns the User-Agent for requests made by the Partner, with its UserAgentSuffix if it has one
+func (partner Partner) userAgent() string {
+	if partner.UserAgentSuffix == "" {
+		return UserAgentHeader
+	}
+
+	return UserAgentHeader + " " + partner.UserAgentSuffix
+}
+
+// url returns the URL for the API path, using the Partner's BaseURL if one is set
+func (partner Partner) url(path string) string {
+	if partner.BaseURL != "" {
+		return strings.TrimSuffix(partner.BaseURL, "/") + path
+	}
+
+	return ApiBaseUrl + path
+}
+
+// getHttpClient returns a *http.Client authenticated as the Partner, using the Partner's TokenSource or service account
+// and its HTTPClient if one is set, otherwise a default client with the Partner's timeouts
+func (partner Partner) getHttpClient(ctx context.Context) (*http.Client, error) {
+	baseClient := partner.baseHttpClient()
+
+	if partner.TokenSource != nil {
+		return oauth2.GetHttpClientFromTokenSource(ctx, partner.TokenSource, baseClient), nil
+	}
+
+	return oauth2.GetHttpClientWithBase(ctx, partner.serviceAccountJSON(), baseClient)
+}
+
+// getToken returns a token for the Partner, from its TokenSource if it has one or its service account otherwise
+func (partner Partner) getToken() (*xoauth2.Token, error) {
+	if partner.TokenSource != nil {
+		token, err := partner.TokenSource.Token()
+		if err != nil {
+			return nil, terrors.Augment(err, "failed to get token", nil)
+		}
+
+		return token, nil
+	}
+
+	return oauth2.GetToken(partner.serviceAccountJSON(), partner.baseHttpClient())
+}
+
+// refreshHttpClient returns a *http.Client like getHttpClient which won't reuse the cached token for the Partner's
+// service account. A Partner's own TokenSource is responsible for refreshing its tokens, so it is used as it is
+func (partner Partner) refreshHttpClient(ctx context.Context) (*http.Client, error) {
+	if partner.TokenSource == nil {
+		oauth2.InvalidateTokens(partner.serviceAccountJSON())
+	}
+
+	return partner.getHttpClient(ctx)
+}
+
+// baseHttpClient returns the Partner's HTTPClient, or a default client with the Partner's timeouts if it doesn't have
+// one
+func (partner Partner) baseHttpClient() *http.Client {
+	if partner.HTTPClient != nil {
+		return partner.HTTPClient
+	}
+
+	return oauth2.DefaultClient(oauth2.Timeouts{
+		Dial:           partner.DialTimeout,
+		TLSHandshake:   partner.TLSHandshakeTimeout,
+		ResponseHeader: partner.ResponseHeaderTimeout,
+		Client:         partner.RequestTimeout,
+	})
+}
@@ -0,0 +1,227 @@
+package verifiedsms
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	xoauth2 "golang.org/x/oauth2"
+	"golang.org/x/time/rate"
+)
+
+func TestRateLimiterCancelledContext(t *testing.T) {
+	partner := newTestPartner(t, newFakeGoogle(nil))
+	partner.RateLimiter = rate.NewLimiter(rate.Every(time.Hour), 1)
+	partner.RateLimiter.Allow()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	_, err := partner.doRequest(ctx, http.MethodPost, apiGetPublicKeysPath, nil, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if terrors.Is(err, terrors.ErrRateLimited) {
+		t.Errorf("expected a cancelled wait not to be rate limited, got %v", err)
+	}
+}
+
+func TestRateLimiterDeadlineWouldPass(t *testing.T) {
+	partner := newTestPartner(t, newFakeGoogle(nil))
+	partner.RateLimiter = rate.NewLimiter(rate.Every(time.Hour), 1)
+	partner.RateLimiter.Allow()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := partner.doRequest(ctx, http.MethodPost, apiGetPublicKeysPath, nil, nil)
+	if !terrors.Is(err, terrors.ErrTimeout) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+}
+
+func TestRateLimiterBurstExceeded(t *testing.T) {
+	partner := newTestPartner(t, newFakeGoogle(nil))
+	partner.RateLimiter = rate.NewLimiter(rate.Every(time.Hour), 0)
+
+	_, err := partner.doRequest(context.Background(), http.MethodPost, apiGetPublicKeysPath, nil, nil)
+	if !terrors.Is(err, terrors.ErrRateLimited) {
+		t.Errorf("expected rate limited, got %v", err)
+	}
+}
+
+func TestRateLimiterBurstExceededWithADeadline(t *testing.T) {
+	partner := newTestPartner(t, newFakeGoogle(nil))
+	partner.RateLimiter = rate.NewLimiter(rate.Every(time.Hour), 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := partner.doRequest(ctx, http.MethodPost, apiGetPublicKeysPath, nil, nil)
+	if !terrors.Is(err, terrors.ErrRateLimited) {
+		t.Errorf("expected a limiter which can never allow the request to be rate limited, got %v", err)
+	}
+}
+
+func TestSendRequestCancelledMidFlight(t *testing.T) {
+	started := make(chan struct{})
+	partner := newTestPartner(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	start := time.Now()
+	_, err := partner.doRequest(ctx, http.MethodPost, apiGetPublicKeysPath, nil, nil)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the request to be aborted promptly, took %s", elapsed)
+	}
+}
+
+func TestSendRequestDeadlineMidFlight(t *testing.T) {
+	partner := newTestPartner(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := partner.doRequest(ctx, http.MethodPost, apiGetPublicKeysPath, nil, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestResponseOverMaxResponseBytes(t *testing.T) {
+	partner := newTestPartner(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"userKeys":[{"phoneNumber":"` + strings.Repeat("1", 10000) + `"}]}`))
+	}))
+	partner.MaxResponseBytes = 1000
+
+	_, err := partner.GetPhoneNumbersPublicKeys(context.Background(), []string{"+447700900001"})
+	if !terrors.Is(err, terrors.ErrBadResponse) {
+		t.Fatalf("expected a bad response error, got %v", err)
+	}
+
+	terr := err.(*terrors.Error)
+	if !strings.Contains(terr.Error(), "exceeded the maximum size") || terr.Params["max_response_bytes"] != "1000" {
+		t.Errorf("expected the error to explain the response was too large, got %v", terr)
+	}
+}
+
+func TestResponseWithinMaxResponseBytes(t *testing.T) {
+	partner := newTestPartner(t, newFakeGoogle(map[string][]string{
+		"+447700900001": {"key"},
+	}))
+	partner.MaxResponseBytes = 1000
+
+	publicKeys, err := partner.GetPhoneNumbersPublicKeys(context.Background(), []string{"+447700900001"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(publicKeys["+447700900001"]) != 1 {
+		t.Errorf("expected the public key, got %v", publicKeys)
+	}
+}
+
+func TestLimitedBodyAllowsExactlyTheLimit(t *testing.T) {
+	body := newLimitedBody(io.NopCloser(strings.NewReader(strings.Repeat("a", 10))), 10)
+
+	read, err := io.ReadAll(body)
+	if err != nil || len(read) != 10 {
+		t.Errorf("expected all 10 bytes to be read, got %d, %v", len(read), err)
+	}
+
+	body = newLimitedBody(io.NopCloser(strings.NewReader(strings.Repeat("a", 11))), 10)
+	if _, err := io.ReadAll(body); !terrors.Is(err, terrors.ErrBadResponse) {
+		t.Errorf("expected a bad response error for 11 bytes, got %v", err)
+	}
+}
+
+// sequenceTokenSource returns token-1, token-2 and so on, one for each call
+type sequenceTokenSource struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (tokenSource *sequenceTokenSource) Token() (*xoauth2.Token, error) {
+	tokenSource.mu.Lock()
+	defer tokenSource.mu.Unlock()
+
+	tokenSource.calls++
+
+	return &xoauth2.Token{AccessToken: "token-" + strconv.Itoa(tokenSource.calls)}, nil
+}
+
+func TestUnauthorizedResponseRetriesWithARefreshedToken(t *testing.T) {
+	var authorizations []string
+	google := newFakeGoogle(map[string][]string{
+		"+447700900001": {"key"},
+	})
+
+	partner := newTestPartner(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+
+		if r.Header.Get("Authorization") != "Bearer token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		google.ServeHTTP(w, r)
+	}))
+	partner.TokenSource = &sequenceTokenSource{}
+
+	publicKeys, err := partner.GetPhoneNumberPublicKeys(context.Background(), "+447700900001")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(publicKeys) != 1 {
+		t.Errorf("expected the public key from the retried request, got %v", publicKeys)
+	}
+
+	expected := []string{"Bearer token-1", "Bearer token-2"}
+	if !reflect.DeepEqual(authorizations, expected) {
+		t.Errorf("expected the retry to use the refreshed token, got %v", authorizations)
+	}
+}
+
+func TestUnauthorizedResponseIsOnlyRetriedOnce(t *testing.T) {
+	requests := 0
+	partner := newTestPartner(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	partner.TokenSource = &sequenceTokenSource{}
+
+	_, err := partner.GetPhoneNumberPublicKeys(context.Background(), "+447700900001")
+	if err == nil {
+		t.Fatalf("expected an error when every token is rejected")
+	}
+
+	if requests != 2 {
+		t.Errorf("expected the request to be retried once, got %d requests", requests)
+	}
+}
@@ -0,0 +1,114 @@
+package verifiedsms
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy configures how requests to Google are retried when they fail with a transient error, a network error or
+// a 429, 500, 502, 503 or 504 response. Other responses are never retried
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times a request will be made, including the first attempt
+	MaxAttempts int
+
+	// BaseDelay is the delay before the first retry, it doubles for every subsequent retry
+	BaseDelay time.Duration
+
+	// MaxDelay caps the delay between retries, if zero the delay isn't capped
+	MaxDelay time.Duration
+
+	// Jitter is the fraction, between 0 and 1, of each delay which is randomised to avoid many clients retrying in
+	// lockstep
+	Jitter float64
+}
+
+// retryableStatusCodes are the HTTP status codes from Google which are worth retrying
+var retryableStatusCodes = map[int]bool{
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// maxAttempts returns the number of attempts allowed by the policy, a nil policy only makes a single attempt
+func (policy *RetryPolicy) maxAttempts() int {
+	if policy == nil || policy.MaxAttempts < 1 {
+		return 1
+	}
+
+	return policy.MaxAttempts
+}
+
+// shouldRetry returns whether a request which returned httpResponse and err should be retried
+func (policy *RetryPolicy) shouldRetry(ctx context.Context, attempt int, httpResponse *http.Response, err error) bool {
+	if attempt >= policy.maxAttempts() || ctx.Err() != nil {
+		return false
+	}
+
+	if err != nil {
+		return true
+	}
+
+	return retryableStatusCodes[httpResponse.StatusCode]
+}
+
+// delay returns how long to wait before making the next attempt, honouring any Retry-After header in httpResponse if
+// it asks us to wait longer than our own backoff
+func (policy *RetryPolicy) delay(clock clock, attempt int, httpResponse *http.Response) time.Duration {
+	backoff := float64(policy.BaseDelay) * math.Pow(2, float64(attempt-1))
+	if policy.MaxDelay > 0 && backoff > float64(policy.MaxDelay) {
+		backoff = float64(policy.MaxDelay)
+	}
+
+	if policy.Jitter > 0 {
+		backoff -= backoff * policy.Jitter * rand.Float64()
+	}
+
+	delay := time.Duration(backoff)
+
+	if httpResponse != nil {
+		if retryAfter := parseRetryAfter(clock, httpResponse.Header.Get("Retry-After")); retryAfter > delay {
+			delay = retryAfter
+		}
+	}
+
+	return delay
+}
+
+// wait blocks for delay, returning false without waiting if the context would expire first or if it's cancelled while
+// waiting
+// The context's deadline is in real time, so it's compared with the real time rather than clock's
+func wait(ctx context.Context, clock clock, delay time.Duration) bool {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		return false
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-clock.After(delay):
+		return true
+	}
+}
+
+// parseRetryAfter parses a Retry-After header, which can either be a number of seconds or a HTTP date
+func parseRetryAfter(clock clock, retryAfter string) time.Duration {
+	if retryAfter == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(retryAfter); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(retryAfter); err == nil {
+		return date.Sub(clock.Now())
+	}
+
+	return 0
+}
@@ -0,0 +1,94 @@
+package verifiedsms
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRetryBackoffSchedule(t *testing.T) {
+	attempts := 0
+	partner := newTestPartner(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+
+	clock := newFakeClock()
+	partner.clock = clock
+	partner.RetryPolicy = &RetryPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   time.Second,
+		MaxDelay:    3 * time.Second,
+	}
+
+	_, err := partner.doRequest(context.Background(), http.MethodPost, apiGetPublicKeysPath, nil, nil)
+	if err == nil {
+		t.Fatalf("expected an error after every attempt failed")
+	}
+
+	if attempts != 4 {
+		t.Errorf("expected 4 attempts, got %d", attempts)
+	}
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}
+	if delays := clock.Delays(); !reflect.DeepEqual(delays, expected) {
+		t.Errorf("expected delays %v, got %v", expected, delays)
+	}
+}
+
+func TestRetryHonoursRetryAfterDate(t *testing.T) {
+	clock := newFakeClock()
+
+	attempts := 0
+	partner := newTestPartner(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("Retry-After", clock.Now().Add(10*time.Second).Format(http.TimeFormat))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		_, _ = w.Write([]byte("{}"))
+	}))
+
+	partner.clock = clock
+	partner.RetryPolicy = &RetryPolicy{
+		MaxAttempts: 2,
+		BaseDelay:   time.Second,
+	}
+
+	_, err := partner.doRequest(context.Background(), http.MethodPost, apiGetPublicKeysPath, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []time.Duration{10 * time.Second}
+	if delays := clock.Delays(); !reflect.DeepEqual(delays, expected) {
+		t.Errorf("expected delays %v, got %v", expected, delays)
+	}
+}
+
+func TestRetryStopsBeforeTheDeadline(t *testing.T) {
+	attempts := 0
+	partner := newTestPartner(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+
+	partner.clock = newFakeClock()
+	partner.RetryPolicy = &RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Hour,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	_, _ = partner.doRequest(ctx, http.MethodPost, apiGetPublicKeysPath, nil, nil)
+
+	if attempts != 1 {
+		t.Errorf("expected no retry when the backoff outlasts the deadline, got %d attempts", attempts)
+	}
+}
@@ -0,0 +1,46 @@
+package verifiedsms
+
+import (
+	"context"
+)
+
+// SendRequest describes a single SMS to mark as verified with Send
+type SendRequest struct {
+	// PhoneNumber is the end users phone number the SMS is sent to
+	PhoneNumber string
+
+	// Agent is the Verified SMS agent the SMS is sent as
+	Agent *Agent
+
+	// Message is the SMS exactly as it's sent
+	Message string
+
+	// Options optionally change how this SMS is verified
+	Options SendOptions
+}
+
+// SendOptions change how a single SMS is verified by Send, without changing the Partner. The zero value verifies the
+// SMS the same way MarkSMSAsVerifiedResult does
+type SendOptions struct {
+	// CorrelationID optionally tags the verification, as with WithCorrelationID
+	CorrelationID string
+
+	// DisableDataMunging hashes only the exact message for this SMS, as with the Partner's DisableDataMunging
+	DisableDataMunging bool
+}
+
+// Send marks the SMS described by request as verified for the end users phone number, returning the outcome
+// It is equivalent to MarkSMSAsVerifiedResult, but takes a request struct so options can be added without changing
+// its signature. The Partner isn't modified, so different agents and options can be used concurrently
+func (partner Partner) Send(ctx context.Context, request SendRequest) (*VerificationResult, error) {
+	if request.Options.CorrelationID != "" {
+		ctx = WithCorrelationID(ctx, request.Options.CorrelationID)
+	}
+
+	// The Partner is a copy, so this only applies to this SMS
+	if request.Options.DisableDataMunging {
+		partner.DisableDataMunging = true
+	}
+
+	return partner.MarkSMSAsVerifiedResult(ctx, request.PhoneNumber, request.Agent, request.Message)
+}
@@ -0,0 +1,66 @@
+package verifiedsms
+
+import (
+	"context"
+	"github.com/monzo/terrors"
+	"strconv"
+)
+
+// CheckSupport reports which of the given end users phone numbers have at least one device registered for Verified
+// SMS, without hashing or submitting anything, e.g. to estimate the reach of a campaign before sending it
+// Public keys are fetched in chunks of at most the Partner's MaxBatchSize numbers. Returns a map of every phone number,
+// as it was passed in, to whether it's supported
+func (partner Partner) CheckSupport(ctx context.Context, phoneNumbers []string) (map[string]bool, error) {
+	ctx, cancel := partner.withOperationTimeout(ctx)
+	defer cancel()
+
+	supported := make(map[string]bool, len(phoneNumbers))
+
+	// Numbers are looked up in E.164 form, and several numbers passed in may resolve to the same one
+	resolvedNumbers := make([]string, 0, len(phoneNumbers))
+	numbersByResolved := map[string][]string{}
+
+	for i, phoneNumber := range phoneNumbers {
+		resolved, err := partner.resolvePhoneNumber(phoneNumber)
+		if err != nil {
+			return nil, terrors.Augment(err, "invalid phone number", map[string]string{
+				"phone_number_index": strconv.Itoa(i),
+			})
+		}
+
+		if _, ok := numbersByResolved[resolved]; !ok {
+			resolvedNumbers = append(resolvedNumbers, resolved)
+		}
+		numbersByResolved[resolved] = append(numbersByResolved[resolved], phoneNumber)
+
+		supported[phoneNumber] = false
+	}
+
+	maxBatchSize := partner.maxBatchSize()
+
+	for start := 0; start < len(resolvedNumbers); start += maxBatchSize {
+		end := start + maxBatchSize
+		if end > len(resolvedNumbers) {
+			end = len(resolvedNumbers)
+		}
+
+		publicKeysByNumber, err := partner.getPublicKeysForPhoneNumbers(ctx, resolvedNumbers[start:end])
+		if err != nil {
+			return nil, terrors.Augment(err, "failed to get public keys from Google", map[string]string{
+				"chunk": strconv.Itoa(start / maxBatchSize),
+			})
+		}
+
+		for resolved, publicKeys := range publicKeysByNumber {
+			if len(publicKeys) == 0 {
+				continue
+			}
+
+			for _, phoneNumber := range numbersByResolved[resolved] {
+				supported[phoneNumber] = true
+			}
+		}
+	}
+
+	return supported, nil
+}
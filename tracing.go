@@ -0,0 +1,37 @@
+package verifiedsms
+
+import (
+	"context"
+)
+
+// Tracer starts spans around verifications and the requests they make to Google, e.g. with the OpenTelemetry
+// implementation in the opentelemetry package
+type Tracer interface {
+	// StartSpan starts a span called name as a child of any span in ctx, and returns a context containing it
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer
+type Span interface {
+	// SetAttributes adds attributes describing the operation to the span
+	SetAttributes(attributes map[string]string)
+
+	// End ends the span, recording err if the operation failed
+	End(err error)
+}
+
+// noopSpan discards everything, it's used when a Partner doesn't have a Tracer
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(map[string]string) {}
+func (noopSpan) End(error)                       {}
+
+// startSpan starts a span with the Partner's Tracer, or returns a span which discards everything if it doesn't have
+// one
+func (partner Partner) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if partner.Tracer == nil {
+		return ctx, noopSpan{}
+	}
+
+	return partner.Tracer.StartSpan(ctx, name)
+}
@@ -0,0 +1,134 @@
+package verifiedsms
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/monzo/terrors"
+	"github.com/monzo/verifiedsms/hashing"
+	"net/http"
+	"strconv"
+)
+
+// UserKey is a public key registered to a phone number on the Verified SMS service, each of the users' devices has
+// its own key
+type UserKey struct {
+	// PhoneNumber is the phone number the key is registered to
+	PhoneNumber string
+
+	// PublicKey is the base64 encoded PKIX public key of the device
+	PublicKey string
+
+	// Fingerprint is the hex encoded SHA-256 of the decoded public key, which identifies the device without having to
+	// store its key. It is empty if the key isn't valid base64
+	Fingerprint string
+}
+
+// GetPhoneNumberUserKeys gets the public keys for a given phone number from the Verified SMS service, like
+// GetPhoneNumberPublicKeys, along with a fingerprint identifying each device
+func (partner Partner) GetPhoneNumberUserKeys(ctx context.Context, phoneNumber string) ([]UserKey, error) {
+	publicKeys, err := partner.GetPhoneNumberPublicKeys(ctx, phoneNumber)
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
+
+	userKeys := make([]UserKey, 0, len(publicKeys))
+	for _, publicKey := range publicKeys {
+		userKeys = append(userKeys, UserKey{
+			PhoneNumber: phoneNumber,
+			PublicKey:   publicKey,
+			Fingerprint: publicKeyFingerprint(publicKey),
+		})
+	}
+
+	return userKeys, nil
+}
+
+// publicKeyFingerprint returns the hex encoded SHA-256 of a base64 encoded public key, or an empty string if it isn't
+// valid base64
+func publicKeyFingerprint(publicKey string) string {
+	publicKeyBytes, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return ""
+	}
+
+	fingerprint := sha256.Sum256(publicKeyBytes)
+
+	return hex.EncodeToString(fingerprint[:])
+}
+
+// EnableUserKeys registers the public keys of users' devices with the Verified SMS service, publicKeys maps each end
+// users phone number to the base64 encoded PKIX public key of their device
+// Every entry is validated before anything is sent to Google, and entries which aren't valid are returned in the map
+// of phone number to error rather than failing the whole batch. The remaining entries are enabled in a single request
+// An error will be returned if the request to Google failed, in which case none of the keys should be considered
+// enabled
+func (partner Partner) EnableUserKeys(ctx context.Context, publicKeys map[string]string) (map[string]error, error) {
+	errs := map[string]error{}
+
+	var userKeys []verifiedSMSResponseUserKeys
+
+	for phoneNumber, publicKey := range publicKeys {
+		err := validateUserKey(partner.HashingOptions, phoneNumber, publicKey)
+		if err != nil {
+			errs[phoneNumber] = terrors.Propagate(err)
+			continue
+		}
+
+		userKeys = append(userKeys, verifiedSMSResponseUserKeys{
+			PhoneNumber: phoneNumber,
+			PublicKey:   publicKey,
+		})
+	}
+
+	if len(userKeys) == 0 {
+		return errs, nil
+	}
+
+	_, err := partner.doRequest(ctx, http.MethodPost, apiEnableUserKeysPath, enableUserKeysRequest{
+		UserKeys: userKeys,
+	}, nil)
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
+
+	if partner.PublicKeyCache != nil {
+		for _, userKey := range userKeys {
+			partner.PublicKeyCache.Invalidate(userKey.PhoneNumber)
+		}
+	}
+
+	partner.logger().Info(ctx, "enabled user keys with Google", map[string]string{
+		"public_key_count": strconv.Itoa(len(userKeys)),
+	})
+
+	return errs, nil
+}
+
+// validateUserKey checks phoneNumber is E.164 and publicKey is an ECDSA public key on a curve options approves
+func validateUserKey(options hashing.Options, phoneNumber string, publicKey string) error {
+	err := ValidatePhoneNumber(phoneNumber)
+	if err != nil {
+		return terrors.Propagate(err)
+	}
+
+	return terrors.Propagate(validatePublicKey(options, publicKey))
+}
+
+// validatePublicKey checks publicKey is a base64 encoded ECDSA public key on a curve options approves, which is only
+// P-384 unless its Curves are set
+func validatePublicKey(options hashing.Options, publicKey string) error {
+	parsedPublicKey, err := hashing.ParsePublicKey(publicKey)
+	if err != nil {
+		return terrors.BadRequest(ErrInvalidArgument, "public key is not a valid ECDSA public key", map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return terrors.Propagate(options.ValidatePublicKey(parsedPublicKey))
+}
+
+type enableUserKeysRequest struct {
+	UserKeys []verifiedSMSResponseUserKeys `json:"userKeys"`
+}
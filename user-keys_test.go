@@ -0,0 +1,74 @@
+package verifiedsms
+
+import (
+	"context"
+	"crypto/elliptic"
+	"net/http"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/monzo/verifiedsms/hashing"
+)
+
+func TestMarkSMSAsVerifiedWithKeysAcceptsKeysOnApprovedCurves(t *testing.T) {
+	google := newFakeGoogle(nil)
+	partner := newTestPartner(t, google)
+	partner.HashingOptions = hashing.Options{
+		Curves: []elliptic.Curve{elliptic.P256()},
+	}
+
+	agent := &Agent{
+		ID:         "test-agent",
+		PrivateKey: newTestPrivateKey(t, elliptic.P256()),
+	}
+
+	result, err := partner.MarkSMSAsVerifiedWithKeys(context.Background(), []string{newTestUserKeyOnCurve(t, elliptic.P256())}, agent, "Your code is 1234")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !result.IsVerified() {
+		t.Errorf("expected the message to be verified, got %s", result.Status)
+	}
+}
+
+func TestMarkSMSAsVerifiedWithKeysRejectsKeysOnOtherCurves(t *testing.T) {
+	google := newFakeGoogle(nil)
+	partner := newTestPartner(t, google)
+
+	_, err := partner.MarkSMSAsVerifiedWithKeys(context.Background(), []string{newTestUserKeyOnCurve(t, elliptic.P256())}, newTestAgent(t), "Your code is 1234")
+	if !terrors.Is(err, terrors.ErrPreconditionFailed) {
+		t.Fatalf("expected a P-256 key to be rejected by default, got %v", err)
+	}
+
+	if requests := google.requestCount(apiSubmitHashesPath); requests != 0 {
+		t.Errorf("expected nothing to be submitted, got %d requests", requests)
+	}
+}
+
+func TestEnableUserKeysAcceptsKeysOnApprovedCurves(t *testing.T) {
+	requests := 0
+	partner := newTestPartner(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	partner.HashingOptions = hashing.Options{
+		Curves: []elliptic.Curve{elliptic.P256()},
+	}
+
+	errs, err := partner.EnableUserKeys(context.Background(), map[string]string{
+		"+447700900001": newTestUserKeyOnCurve(t, elliptic.P256()),
+		"+447700900002": newTestUserKey(t),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(errs) != 1 || !terrors.Is(errs["+447700900002"], terrors.ErrPreconditionFailed) {
+		t.Errorf("expected only the P-384 key to be rejected when only P-256 is approved, got %v", errs)
+	}
+
+	if requests != 1 {
+		t.Errorf("expected the P-256 key to be enabled, got %d requests", requests)
+	}
+}
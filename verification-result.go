@@ -0,0 +1,88 @@
+package verifiedsms
+
+// VerificationStatus describes the outcome of trying to mark an SMS as verified
+type VerificationStatus int
+
+const (
+	// Unknown is the zero value, it means the outcome hasn't been set, so a result which was never filled in isn't
+	// mistaken for one of the others
+	Unknown VerificationStatus = iota
+
+	// NotSupported means the users' device doesn't support Verified SMS, so nothing was submitted to Google
+	NotSupported
+
+	// Verified means the SMS was marked as verified for every device registered to the phone number
+	Verified
+
+	// PartiallyVerified means the SMS was marked as verified for some, but not all, of the devices registered to the
+	// phone number
+	PartiallyVerified
+)
+
+// String returns a human readable name for the status, suitable for logging
+func (status VerificationStatus) String() string {
+	switch status {
+	case NotSupported:
+		return "not_supported"
+	case Verified:
+		return "verified"
+	case PartiallyVerified:
+		return "partially_verified"
+	default:
+		return "unknown"
+	}
+}
+
+// VerificationResult describes the outcome of marking an SMS as verified for a single phone number
+type VerificationResult struct {
+	// Status is the outcome of the verification
+	Status VerificationStatus
+
+	// PublicKeyCount is the number of public keys registered to the phone number
+	PublicKeyCount int
+
+	// HashCount is the number of hashes submitted to Google
+	HashCount int
+
+	// HashResults describes whether Google stored each of the submitted hashes
+	HashResults []HashResult
+
+	// RequestIDs are Google's identifiers for the requests which submitted the hashes, if Google returned them
+	RequestIDs []string
+
+	// KeyErrors maps each of the users' public keys which the message couldn't be hashed for, e.g. because it isn't a
+	// valid P-384 key, to why. The message is still verified for the users' other devices
+	KeyErrors map[string]error
+
+	// CorrelationID is the correlation ID the verification was tagged with by WithCorrelationID, if any
+	CorrelationID string
+}
+
+// HashResult describes whether Google stored a single submitted hash
+type HashResult struct {
+	// Hash is the base64 encoded hash which was submitted
+	Hash string
+
+	// Created is true if Google stored the hash
+	Created bool
+
+	// Error describes why Google didn't store the hash, it is nil if Created is true
+	Error error
+}
+
+// FailedHashCount returns the number of submitted hashes which Google didn't store
+func (result VerificationResult) FailedHashCount() int {
+	failed := 0
+	for _, hashResult := range result.HashResults {
+		if !hashResult.Created {
+			failed++
+		}
+	}
+
+	return failed
+}
+
+// IsVerified returns true if the SMS was marked as verified for at least one of the users' devices
+func (result VerificationResult) IsVerified() bool {
+	return result.Status == Verified || result.Status == PartiallyVerified
+}
@@ -0,0 +1,32 @@
+package verifiedsms
+
+import (
+	"testing"
+)
+
+func TestVerificationStatusZeroValueIsUnknown(t *testing.T) {
+	result := VerificationResult{}
+
+	if result.Status != Unknown || result.Status.String() != "unknown" {
+		t.Errorf("expected the zero value to be unknown, got %s", result.Status)
+	}
+
+	if result.IsVerified() {
+		t.Errorf("expected an unknown result not to be verified")
+	}
+}
+
+func TestVerificationStatusString(t *testing.T) {
+	cases := map[VerificationStatus]string{
+		Unknown:           "unknown",
+		NotSupported:      "not_supported",
+		Verified:          "verified",
+		PartiallyVerified: "partially_verified",
+	}
+
+	for status, expected := range cases {
+		if status.String() != expected {
+			t.Errorf("expected %s, got %s", expected, status.String())
+		}
+	}
+}
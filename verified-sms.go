@@ -1,29 +1,254 @@
 package verifiedsms
 
 import (
-	"bytes"
 	"context"
 	"crypto/ecdsa"
 	"encoding/base64"
-	"encoding/json"
 	"github.com/monzo/terrors"
 	data_munging "github.com/monzo/verifiedsms/data-munging"
 	"github.com/monzo/verifiedsms/hashing"
 	"github.com/monzo/verifiedsms/oauth2"
+	xoauth2 "golang.org/x/oauth2"
+	"golang.org/x/time/rate"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
 const (
-	ApiGetPublicKeysUrl = "https://verifiedsms.googleapis.com/v1/enabledUserKeys:batchGet"
-	ApiSubmitHashesUrl  = "https://verifiedsms.googleapis.com/v1/messages:batchCreate"
-	ContentTypeHeader   = "application/json"
-	UserAgentHeader     = "monzo/verifiedsms"
+	ApiBaseUrl           = "https://verifiedsms.googleapis.com"
+	ApiGetPublicKeysUrl  = ApiBaseUrl + apiGetPublicKeysPath
+	ApiSubmitHashesUrl   = ApiBaseUrl + apiSubmitHashesPath
+	ApiDeleteHashesUrl   = ApiBaseUrl + apiDeleteHashesPath
+	ApiGetHashesUrl      = ApiBaseUrl + apiGetHashesPath
+	ApiEnableUserKeysUrl = ApiBaseUrl + apiEnableUserKeysPath
+	ApiAgentsUrl         = ApiBaseUrl + apiAgentsPath
+	ContentTypeHeader    = "application/json"
+	UserAgentHeader      = "monzo/verifiedsms"
+
+	// DefaultMaxBatchSize is the maximum number of entries sent to Google in a single batch request by default
+	DefaultMaxBatchSize = 1000
+
+	// DefaultMaxResponseBytes is the largest response body read from Google by default, which is far more than any
+	// legitimate response
+	DefaultMaxResponseBytes = 10 << 20
+
+	// DefaultCompressionThreshold is the smallest request body gzipped when the Partner's CompressRequests is set, unless
+	// its CompressionThreshold is set. Smaller bodies aren't worth the CPU
+	DefaultCompressionThreshold = 1 << 10
+)
+
+const (
+	apiGetPublicKeysPath  = "/v1/enabledUserKeys:batchGet"
+	apiSubmitHashesPath   = "/v1/messages:batchCreate"
+	apiDeleteHashesPath   = "/v1/messages:batchDelete"
+	apiGetHashesPath      = "/v1/messages:batchGet"
+	apiEnableUserKeysPath = "/v1/enabledUserKeys:batchCreate"
+	apiAgentsPath         = "/v1/agents"
 )
 
 type Partner struct {
-	// The JSON keys for a service account that will make requests to create messages and enable user keys as the
-	// Verified SMS partner
+	// The contents of the JSON keys file for a service account that will make requests to create messages and enable
+	// user keys as the Verified SMS partner. If empty, Application Default Credentials are used instead
+	ServiceAccountJSON string
+
+	// Deprecated: despite its name this holds the contents of the JSON keys file rather than a path to it, use
+	// ServiceAccountJSON instead. It is only used if ServiceAccountJSON is empty
 	ServiceAccountJSONFile string
+
+	// TokenSource optionally provides the tokens requests are authenticated with instead of the service account, e.g.
+	// when credentials are managed centrally or impersonate a service account
+	TokenSource xoauth2.TokenSource
+
+	// HTTPClient is an optional client whose transport, timeouts and connection pool will be used for requests to
+	// Google, authenticated as the service account. If nil a shared default client with the timeouts below is used
+	HTTPClient *http.Client
+
+	// DialTimeout, TLSHandshakeTimeout, ResponseHeaderTimeout and RequestTimeout configure the default client used when
+	// HTTPClient is nil, and are ignored otherwise. If zero oauth2.DefaultDialTimeout,
+	// oauth2.DefaultTLSHandshakeTimeout, oauth2.DefaultResponseHeaderTimeout and oauth2.DefaultClientTimeout are used
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	RequestTimeout        time.Duration
+
+	// RetryPolicy configures retries of requests to Google which fail with a transient error. If nil every request is
+	// only attempted once
+	RetryPolicy *RetryPolicy
+
+	// RateLimiter optionally limits how often requests are sent to Google, e.g. rate.NewLimiter(requestsPerSecond, burst)
+	// so bursts stay within Google's quotas. Every attempt waits for the limiter, and stops waiting if the context is
+	// done. If nil requests aren't limited
+	RateLimiter *rate.Limiter
+
+	// PublicKeyCache is an optional cache of the public keys registered to phone numbers. If nil public keys are
+	// fetched from Google for every request
+	PublicKeyCache *PublicKeyCache
+
+	// PublicKeyCoalescer optionally batches concurrent public key lookups into fewer requests to Google. It should only
+	// be shared between Partners with the same credentials
+	PublicKeyCoalescer *PublicKeyCoalescer
+
+	// BaseURL overrides the scheme and host requests are sent to, e.g. to point at a mock server in tests. If empty
+	// requests are sent to ApiBaseUrl
+	BaseURL string
+
+	// HashingConcurrency is the maximum number of public keys hashed in parallel for a single message. If zero it
+	// defaults to GOMAXPROCS
+	HashingConcurrency int
+
+	// Transformers produce the variants of each SMS message which are hashed, to account for data munging by phone
+	// carriers. Each is applied to the original message in order, and if nil data_munging.DefaultTransformers are used
+	Transformers []data_munging.Transformer
+
+	// DisableDataMunging hashes only the exact message, ignoring Transformers, so just one hash is submitted for each
+	// public key
+	DisableDataMunging bool
+
+	// DefaultRegion is optionally the ISO 3166-1 code, e.g. "GB", of the region phone numbers in national format are
+	// from. If set, numbers passed to MarkSMSAsVerified and GetPhoneNumberPublicKeys are normalised to E.164 with
+	// NormalizeNumber first, otherwise they must already be in E.164 form
+	DefaultRegion string
+
+	// MaxIterations caps the number of variants of each message which are hashed, as every variant adds a hash for
+	// each public key. Variants are kept in the order they're produced, so the original message and the variants from
+	// the first Transformers are always kept. If zero the number of variants isn't capped
+	MaxIterations int
+
+	// SegmentVariants also hashes the forms a device might show a message which doesn't fit in a single SMS as if it
+	// doesn't reassemble the parts exactly, i.e. each part on its own and the parts joined by a space or a line break.
+	// Parts are split with GSM-7 or UCS-2 segmentation, as ValidateMessageLength counts them. These variants come after
+	// those from the Transformers, so they're the first to go if MaxIterations is reached
+	SegmentVariants bool
+
+	// Observer is optionally notified of verification outcomes and calls to Google for metrics
+	Observer Observer
+
+	// OnInvalidPublicKey is optionally called for each of the users' public keys that a message couldn't be hashed
+	// for while marking it as verified, e.g. because the key is corrupt or isn't on the P-384 curve, so they can be
+	// counted and reported to Google. The message is still verified for the users' other keys. phoneNumber is empty
+	// if the keys were passed to MarkSMSAsVerifiedWithKeys
+	OnInvalidPublicKey func(ctx context.Context, phoneNumber string, publicKey string, err error)
+
+	// Tracer optionally starts a span for each verification, with a child span for each request to Google
+	Tracer Tracer
+
+	// Logger optionally receives logs of requests made to Google, if nil nothing is logged
+	Logger Logger
+
+	// UserAgentSuffix is optionally appended to the User-Agent of every request to Google to identify the service
+	// using this library, e.g. "myservice/1.2.3"
+	UserAgentSuffix string
+
+	// MaxResponseBytes is the largest response body that will be read from Google, if zero DefaultMaxResponseBytes is
+	// used
+	MaxResponseBytes int64
+
+	// CompressRequests gzips request bodies of at least CompressionThreshold bytes and sends them with
+	// Content-Encoding: gzip. The hashes in large batch submissions don't compress much but the JSON around them does.
+	// Google doesn't document whether the Verified SMS API accepts compressed requests, so check it does before enabling
+	// this in production
+	CompressRequests bool
+
+	// CompressionThreshold is the smallest request body gzipped when CompressRequests is set, if zero
+	// DefaultCompressionThreshold is used
+	CompressionThreshold int
+
+	// OperationTimeout optionally bounds each whole operation, e.g. both fetching public keys and submitting hashes in
+	// MarkSMSAsVerified share this budget. If the context passed in already has an earlier deadline, that deadline is
+	// kept
+	OperationTimeout time.Duration
+
+	// VerificationConcurrency is the maximum number of phone numbers verified in parallel by
+	// MarkSMSAsVerifiedForNumbers, if zero DefaultVerificationConcurrency is used
+	VerificationConcurrency int
+
+	// MaxListPages is the most pages of results fetched by ListAgents and AgentPages, so a misbehaving page token can't
+	// make them iterate forever. If zero DefaultMaxListPages is used
+	MaxListPages int
+
+	// MaxListResults optionally caps the number of results returned by ListAgents and AgentPages, if zero every result
+	// is returned
+	MaxListResults int
+
+	// MaxBatchSize is the maximum number of hashes submitted to Google in a single request, larger submissions are
+	// split into several requests. If zero DefaultMaxBatchSize is used
+	MaxBatchSize int
+
+	// HashEncoding is the base64 encoding hashes are submitted to Google and returned by ComputeVerificationHashes in.
+	// If nil base64.StdEncoding is used, which is what Google expects, so base64.URLEncoding should only be used when
+	// the hashes are handed to other tooling
+	HashEncoding *base64.Encoding
+
+	// HashingOptions configures how hashes are derived, i.e. their length, the HKDF salt and info and which curves keys
+	// may use. The zero value derives hashes as Verified SMS expects, Google won't match hashes derived any other way
+	// so it should only be changed to test against other parameters
+	HashingOptions hashing.Options
+
+	// closed is set by Close, after which requests fail
+	closed bool
+
+	// clock tells the time when backing off between retries, if nil the real time is used
+	clock clock
+}
+
+// NewPartnerFromFile returns a Partner which authenticates using the JSON keys file for a service account found at path
+func NewPartnerFromFile(path string) (*Partner, error) {
+	serviceAccountJSON, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, terrors.NotFound(
+				terrors.ErrNotFound,
+				"service account JSON keys file does not exist",
+				map[string]string{
+					"path": path,
+				},
+			)
+		}
+
+		return nil, terrors.Augment(err, "failed to read service account JSON keys file", map[string]string{
+			"path": path,
+		})
+	}
+
+	return NewPartnerFromJSON(serviceAccountJSON)
+}
+
+// NewPartnerFromSecretManager returns a Partner which authenticates using the JSON keys file for a service account
+// stored in the Secret Manager secret with the given resource name, see oauth2.GetServiceAccountJSONFromSecretManager
+// The secret is read once, when the Partner is created
+func NewPartnerFromSecretManager(ctx context.Context, resourceName string) (*Partner, error) {
+	serviceAccountJSON, err := oauth2.GetServiceAccountJSONFromSecretManager(ctx, resourceName)
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
+
+	return NewPartnerFromJSON(serviceAccountJSON)
+}
+
+// NewPartnerFromTokenSource returns a Partner which authenticates using tokens from tokenSource
+func NewPartnerFromTokenSource(tokenSource xoauth2.TokenSource) *Partner {
+	return &Partner{
+		TokenSource: tokenSource,
+	}
+}
+
+// NewPartnerFromJSON returns a Partner which authenticates using the contents of the JSON keys file for a service
+// account
+func NewPartnerFromJSON(serviceAccountJSON []byte) (*Partner, error) {
+	if len(serviceAccountJSON) == 0 {
+		return nil, terrors.BadRequest(ErrInvalidArgument, "service account JSON keys must not be empty", nil)
+	}
+
+	return &Partner{
+		ServiceAccountJSON: string(serviceAccountJSON),
+	}, nil
 }
 
 type Agent struct {
@@ -32,6 +257,11 @@ type Agent struct {
 
 	// The private key of the Verified SMS agent to use
 	PrivateKey *ecdsa.PrivateKey
+
+	// PreviousPrivateKeys are other private keys the agent is still registered with while its key is being rotated,
+	// hashes are submitted and deleted for PrivateKey and each of these so messages are verified whichever key the
+	// users' device knows about
+	PreviousPrivateKeys []*ecdsa.PrivateKey
 }
 
 // MarkSMSAsVerified marks a given SMS as verified for a given end users phone number
@@ -41,129 +271,964 @@ type Agent struct {
 // device just doesn't support Verified SMS
 // An error will be returned if we couldn't mark the SMS as Verified and we aren't sure whether the user is on
 // Verified SMS
+// Hashes don't expire, as messages:batchCreate has no creation time or expiry, so verification of a message which
+// should stop being trusted must be withdrawn with UnverifySMS
 func (partner Partner) MarkSMSAsVerified(ctx context.Context, phoneNumber string, agent *Agent, smsMessage string) (bool, error) {
-	publicKeys, err := partner.GetPhoneNumberPublicKeys(ctx, phoneNumber)
+	result, err := partner.MarkSMSAsVerifiedResult(ctx, phoneNumber, agent, smsMessage)
 	if err != nil {
 		return false, terrors.Propagate(err)
 	}
 
-	if len(publicKeys) == 0 {
-		return false, nil
+	return result.IsVerified(), nil
+}
+
+// MarkSMSAsVerifiedStrict marks a given SMS as verified for a given end users phone number in the same way as
+// MarkSMSAsVerified, but returns ErrNumberNotVerified rather than false if the users' device doesn't support Verified
+// SMS
+func (partner Partner) MarkSMSAsVerifiedStrict(ctx context.Context, phoneNumber string, agent *Agent, smsMessage string) error {
+	wasVerified, err := partner.MarkSMSAsVerified(ctx, phoneNumber, agent, smsMessage)
+	if err != nil {
+		return terrors.Propagate(err)
 	}
 
-	var messagesToGoogle []messageSubmissionToGoogle
+	if !wasVerified {
+		return ErrNumberNotVerified
+	}
 
-	smsMessages := data_munging.GetAllIterationsOfSMSMessage(smsMessage)
+	return nil
+}
+
+// MarkSMSAsVerifiedResult marks a given SMS as verified for a given end users phone number in the same way as
+// MarkSMSAsVerified, but returns a VerificationResult which explicitly states whether the SMS was verified or the
+// users' device doesn't support Verified SMS
+func (partner Partner) MarkSMSAsVerifiedResult(ctx context.Context, phoneNumber string, agent *Agent, smsMessage string) (*VerificationResult, error) {
+	return partner.MarkSMSAsVerifiedForAgents(ctx, phoneNumber, []*Agent{agent}, smsMessage)
+}
+
+// MarkSMSAsVerifiedBytes marks a given SMS given as its exact bytes as verified for a given end users phone number in
+// the same way as MarkSMSAsVerifiedResult, the bytes are hashed exactly as given
+// Data munging only applies to text, so it is skipped if smsMessage isn't valid UTF-8 and only the exact bytes are
+// verified
+func (partner Partner) MarkSMSAsVerifiedBytes(ctx context.Context, phoneNumber string, agent *Agent, smsMessage []byte) (*VerificationResult, error) {
+	if !utf8.Valid(smsMessage) {
+		partner.DisableDataMunging = true
+	}
+
+	// Converting to a string copies the bytes as they are, it doesn't validate or re-encode them
+	return partner.MarkSMSAsVerifiedResult(ctx, phoneNumber, agent, string(smsMessage))
+}
 
-	for _, publicKey := range publicKeys {
-		for _, smsMessageEntry := range smsMessages {
-			hash, err := hashing.GetHashForSMSMessage(publicKey, agent.PrivateKey, []byte(smsMessageEntry))
+// MarkSMSAsVerifiedForAgents marks a given SMS as verified for a given end users phone number as if it was sent from
+// each of the given agents, submitting the hashes for every agent to Google in a single request
+// This lets a message be verified under whichever brand the users' device expects, callers with their own selection
+// logic should pass only the agents it selects
+func (partner Partner) MarkSMSAsVerifiedForAgents(ctx context.Context, phoneNumber string, agents []*Agent, smsMessage string) (*VerificationResult, error) {
+	ctx, cancel := partner.withOperationTimeout(ctx)
+	defer cancel()
+
+	return partner.observeVerification(ctx, func(ctx context.Context) (*VerificationResult, error) {
+		return partner.markSMSAsVerified(ctx, phoneNumber, agents, smsMessage)
+	})
+}
+
+// MarkSMSAsVerifiedWithKeys marks a given SMS as verified for the devices with the given base64 encoded public keys,
+// e.g. keys previously fetched with GetPhoneNumberPublicKeys, without fetching them from Google again
+// Every key is checked before any hashes are computed, and an error is returned if any of them isn't a valid public
+// key on a curve the Partner's HashingOptions approve
+func (partner Partner) MarkSMSAsVerifiedWithKeys(ctx context.Context, publicKeys []string, agent *Agent, smsMessage string) (*VerificationResult, error) {
+	ctx, cancel := partner.withOperationTimeout(ctx)
+	defer cancel()
+
+	return partner.observeVerification(ctx, func(ctx context.Context) (*VerificationResult, error) {
+		for i, publicKey := range publicKeys {
+			err := validatePublicKey(partner.HashingOptions, publicKey)
 			if err != nil {
-				return false, terrors.Propagate(err)
+				return nil, terrors.Augment(err, "invalid public key", map[string]string{
+					"public_key_index": strconv.Itoa(i),
+				})
 			}
-
-			messagesToGoogle = append(messagesToGoogle, messageSubmissionToGoogle{
-				Hash:    base64.StdEncoding.EncodeToString(hash),
-				AgentId: agent.ID,
-			})
 		}
+
+		return partner.markSMSAsVerifiedWithKeys(ctx, "", publicKeys, []*Agent{agent}, smsMessage)
+	})
+}
+
+// observeVerification runs verify in a span, reporting its outcome and duration to the Partner's Observer if it has
+// one
+func (partner Partner) observeVerification(ctx context.Context, verify func(ctx context.Context) (*VerificationResult, error)) (*VerificationResult, error) {
+	ctx, span := partner.startSpan(ctx, "verifiedsms.MarkSMSAsVerified")
+
+	start := time.Now()
+	result, err := verify(ctx)
+
+	correlationID := CorrelationIDFromContext(ctx)
+	if correlationID != "" {
+		span.SetAttributes(map[string]string{
+			"verifiedsms.correlation_id": correlationID,
+		})
+	}
+
+	if result != nil {
+		result.CorrelationID = correlationID
+
+		span.SetAttributes(map[string]string{
+			"verifiedsms.status":           result.Status.String(),
+			"verifiedsms.verified":         strconv.FormatBool(result.IsVerified()),
+			"verifiedsms.public_key_count": strconv.Itoa(result.PublicKeyCount),
+			"verifiedsms.hash_count":       strconv.Itoa(result.HashCount),
+		})
+	}
+	span.End(err)
+
+	if partner.Observer != nil {
+		partner.Observer.ObserveVerification(result, time.Since(start), err)
+	}
+
+	return result, err
+}
+
+func (partner Partner) markSMSAsVerified(ctx context.Context, phoneNumber string, agents []*Agent, smsMessage string) (*VerificationResult, error) {
+	if len(agents) == 0 {
+		return nil, terrors.BadRequest(ErrInvalidArgument, "at least one agent is required", nil)
 	}
 
-	requestStruct := batchSubmitRequest{
-		Messages: messagesToGoogle,
+	// The agents are checked before looking up the users' keys so a bad agent doesn't cost a request to Google
+	for _, agent := range agents {
+		err := agent.validate(partner.HashingOptions)
+		if err != nil {
+			return nil, terrors.Propagate(err)
+		}
 	}
 
-	requestBody, err := json.Marshal(requestStruct)
+	phoneNumber, err := partner.resolvePhoneNumber(phoneNumber)
 	if err != nil {
-		return false, terrors.Propagate(err)
+		return nil, terrors.Propagate(err)
 	}
 
-	request, err := http.NewRequest("POST", ApiSubmitHashesUrl, bytes.NewReader(requestBody))
+	publicKeys, err := partner.GetPhoneNumberPublicKeys(ctx, phoneNumber)
 	if err != nil {
-		return false, terrors.Propagate(err)
+		return nil, terrors.Propagate(err)
 	}
 
-	request.Header.Set("Content-Type", ContentTypeHeader)
-	request.Header.Set("User-Agent", UserAgentHeader)
+	return partner.markSMSAsVerifiedWithKeys(ctx, phoneNumber, publicKeys, agents, smsMessage)
+}
 
-	client, err := oauth2.GetHttpClient(ctx, partner.ServiceAccountJSONFile)
-	if err != nil {
-		return false, terrors.Propagate(err)
+// markSMSAsVerifiedWithKeys submits the hashes of a given SMS sent by each of the agents to the devices with the given
+// public keys
+func (partner Partner) markSMSAsVerifiedWithKeys(ctx context.Context, phoneNumber string, publicKeys []string, agents []*Agent, smsMessage string) (*VerificationResult, error) {
+	if len(agents) == 0 {
+		return nil, terrors.BadRequest(ErrInvalidArgument, "at least one agent is required", nil)
+	}
+
+	if len(publicKeys) == 0 {
+		return &VerificationResult{
+			Status: NotSupported,
+		}, nil
+	}
+
+	var messagesToGoogle []messageSubmissionToGoogle
+	keyErrors := map[string]error{}
+
+	for _, agent := range agents {
+		messages, agentKeyErrors, err := partner.getMessagesToGoogle(publicKeys, agent, smsMessage)
+
+		for publicKey, keyErr := range agentKeyErrors {
+			keyErrors[publicKey] = keyErr
+		}
+
+		if err != nil {
+			partner.reportKeyErrors(ctx, phoneNumber, keyErrors)
+			return nil, terrors.Propagate(err)
+		}
+
+		messagesToGoogle = append(messagesToGoogle, messages...)
 	}
 
-	httpResponse, err := client.Do(request)
+	partner.reportKeyErrors(ctx, phoneNumber, keyErrors)
+
+	hashResults, requestIDs, err := partner.submitHashes(ctx, messagesToGoogle)
 	if err != nil {
-		return false, terrors.Propagate(err)
+		return nil, terrors.Propagate(err)
 	}
 
-	if httpResponse.StatusCode < 200 || httpResponse.StatusCode > 299 {
-		return false, terrors.InternalService(
+	result := &VerificationResult{
+		Status:         Verified,
+		PublicKeyCount: len(publicKeys),
+		HashCount:      len(hashResults),
+		HashResults:    hashResults,
+		RequestIDs:     requestIDs,
+		KeyErrors:      keyErrors,
+	}
+
+	switch result.FailedHashCount() {
+	case 0:
+	case len(hashResults):
+		return nil, terrors.InternalService(
 			terrors.ErrInternalService,
-			"bad response from Google: "+httpResponse.Status,
-			nil,
+			"Google didn't store any of the submitted hashes",
+			map[string]string{
+				"hash_count":        strconv.Itoa(len(hashResults)),
+				"error":             hashResults[0].Error.Error(),
+				"google_request_id": strings.Join(requestIDs, ","),
+			},
 		)
+	default:
+		result.Status = PartiallyVerified
 	}
 
-	return true, nil
+	// The devices whose keys the message couldn't be hashed for won't show it as verified either
+	if len(keyErrors) > 0 {
+		result.Status = PartiallyVerified
+	}
+
+	return result, nil
+}
+
+// BatchMarkSMSAsVerified marks a set of SMS messages as verified, smsMessages maps each end users phone number to the
+// content of the message sent to them
+// The public keys for every phone number are fetched in a single request and all of the hashes are submitted to Google
+// in a single request
+// Returns a map of phone number to whether the SMS was verified, this will be false if the users' device just doesn't
+// support Verified SMS or if we couldn't hash the message for that number, in which case the error is returned in the
+// map of phone number to error
+// If the Partner has a DefaultRegion, phone numbers may be in national format, results are still keyed by the numbers
+// as they were passed in
+// An error will be returned if either of the requests to Google failed, in which case none of the SMS messages should
+// be considered verified
+func (partner Partner) BatchMarkSMSAsVerified(ctx context.Context, smsMessages map[string]string, agent *Agent) (map[string]bool, map[string]error, error) {
+	err := agent.validate(partner.HashingOptions)
+	if err != nil {
+		return nil, nil, terrors.Propagate(err)
+	}
+
+	ctx, cancel := partner.withOperationTimeout(ctx)
+	defer cancel()
+
+	verified := make(map[string]bool, len(smsMessages))
+	errs := map[string]error{}
+
+	// Numbers are looked up in E.164 form, and results are reported against the numbers as they were passed in. Several
+	// numbers passed in may resolve to the same one, which is only looked up once
+	resolvedNumbers := make(map[string]string, len(smsMessages))
+	phoneNumbers := make([]string, 0, len(smsMessages))
+	lookedUp := map[string]bool{}
+
+	for phoneNumber := range smsMessages {
+		verified[phoneNumber] = false
+
+		resolved, err := partner.resolvePhoneNumber(phoneNumber)
+		if err != nil {
+			errs[phoneNumber] = terrors.Propagate(err)
+			continue
+		}
+
+		if !lookedUp[resolved] {
+			phoneNumbers = append(phoneNumbers, resolved)
+			lookedUp[resolved] = true
+		}
+		resolvedNumbers[phoneNumber] = resolved
+	}
+
+	if len(phoneNumbers) == 0 {
+		return verified, errs, nil
+	}
+
+	publicKeysByNumber, err := partner.getPublicKeysForPhoneNumbers(ctx, phoneNumbers)
+	if err != nil {
+		return nil, nil, terrors.Propagate(err)
+	}
+
+	var messagesToGoogle []messageSubmissionToGoogle
+
+	// Numbers passed in which resolve to the same number with the same message share their hashes
+	phoneNumbersByHash := map[string][]string{}
+
+	for phoneNumber, resolved := range resolvedNumbers {
+		smsMessage := smsMessages[phoneNumber]
+
+		publicKeys := publicKeysByNumber[resolved]
+		if len(publicKeys) == 0 {
+			continue
+		}
+
+		messages, keyErrors, err := partner.getMessagesToGoogle(publicKeys, agent, smsMessage)
+		partner.reportKeyErrors(ctx, resolved, keyErrors)
+		if err != nil {
+			errs[phoneNumber] = terrors.Propagate(err)
+			continue
+		}
+
+		for _, message := range messages {
+			phoneNumbersByHash[message.Hash] = append(phoneNumbersByHash[message.Hash], phoneNumber)
+		}
+
+		messagesToGoogle = append(messagesToGoogle, messages...)
+	}
+
+	if len(messagesToGoogle) == 0 {
+		return verified, errs, nil
+	}
+
+	hashResults, _, err := partner.submitHashes(ctx, messagesToGoogle)
+	if err != nil {
+		return nil, nil, terrors.Propagate(err)
+	}
+
+	// A number is verified as long as Google stored at least one of its hashes
+	for _, hashResult := range hashResults {
+		for _, phoneNumber := range phoneNumbersByHash[hashResult.Hash] {
+			if hashResult.Created {
+				verified[phoneNumber] = true
+				delete(errs, phoneNumber)
+			} else if !verified[phoneNumber] {
+				errs[phoneNumber] = hashResult.Error
+			}
+		}
+	}
+
+	return verified, errs, nil
+}
+
+// ComputeVerificationHashes returns the hashes that MarkSMSAsVerified would submit to Google for a given SMS sent to a
+// given end users phone number, encoded with the Partner's HashEncoding, without submitting them
+// The slice will be empty if the users' device doesn't support Verified SMS
+func (partner Partner) ComputeVerificationHashes(ctx context.Context, phoneNumber string, agent *Agent, smsMessage string) ([]string, error) {
+	err := agent.validate(partner.HashingOptions)
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
+
+	publicKeys, err := partner.GetPhoneNumberPublicKeys(ctx, phoneNumber)
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
+
+	messagesToGoogle, _, err := partner.getMessagesToGoogle(publicKeys, agent, smsMessage)
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
+
+	// Repeated hashes are only submitted once, so they're only returned once
+	messagesToGoogle = dedupeMessages(messagesToGoogle)
+
+	hashes := make([]string, 0, len(messagesToGoogle))
+	for _, message := range messagesToGoogle {
+		hashes = append(hashes, message.Hash)
+	}
+
+	return hashes, nil
+}
+
+// DeleteVerifiedMessages withdraws verification of a given SMS previously marked as verified for a given end users
+// phone number, deleting exactly the hashes that MarkSMSAsVerified would have submitted
+// Nothing is deleted if the users' device doesn't support Verified SMS
+func (partner Partner) DeleteVerifiedMessages(ctx context.Context, agent *Agent, smsMessage string, phoneNumber string) error {
+	return partner.UnverifySMS(ctx, phoneNumber, agent, smsMessage)
+}
+
+// UnverifySMS withdraws verification of a given SMS previously marked as verified for a given end users phone number
+// It computes the hashes with the same iterations and hashing as MarkSMSAsVerified, so it deletes exactly the hashes
+// MarkSMSAsVerified submitted as long as the users' keys and the Partner's data munging haven't changed
+// Hashes are deleted for each of the agent's private keys, so once a key rotation completes the old key's hashes can be
+// cleaned up by passing an Agent with only the old key as its PrivateKey
+func (partner Partner) UnverifySMS(ctx context.Context, phoneNumber string, agent *Agent, smsMessage string) error {
+	err := agent.validate(partner.HashingOptions)
+	if err != nil {
+		return terrors.Propagate(err)
+	}
+
+	ctx, cancel := partner.withOperationTimeout(ctx)
+	defer cancel()
+
+	publicKeys, err := partner.GetPhoneNumberPublicKeys(ctx, phoneNumber)
+	if err != nil {
+		return terrors.Propagate(err)
+	}
+
+	if len(publicKeys) == 0 {
+		return nil
+	}
+
+	messagesToGoogle, _, err := partner.getMessagesToGoogle(publicKeys, agent, smsMessage)
+	if err != nil {
+		return terrors.Propagate(err)
+	}
+
+	return terrors.Propagate(partner.deleteHashes(ctx, messagesToGoogle))
 }
 
 // GetPhoneNumberPublicKeys gets the public keys for a given phone number from the Verified SMS service and returns them
 // as a slice of strings
+// If the Partner has a DefaultRegion, phoneNumber may be in national format and is normalised to E.164 first
 func (partner Partner) GetPhoneNumberPublicKeys(ctx context.Context, phoneNumber string) ([]string, error) {
-	requestBody, err := json.Marshal(map[string][]string{
-		"phoneNumbers": {
-			phoneNumber,
-		},
-	})
+	phoneNumber, err := partner.resolvePhoneNumber(phoneNumber)
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
 
+	publicKeysByNumber, err := partner.getPublicKeysForPhoneNumbers(ctx, []string{phoneNumber})
 	if err != nil {
 		return nil, terrors.Propagate(err)
 	}
 
-	request, err := http.NewRequest("POST", ApiGetPublicKeysUrl, bytes.NewReader(requestBody))
+	return publicKeysByNumber[phoneNumber], nil
+}
 
+// CountPhoneNumberKeys returns the number of public keys, and so devices, registered to a given phone number on the
+// Verified SMS service. This will be zero if the users' device doesn't support Verified SMS
+func (partner Partner) CountPhoneNumberKeys(ctx context.Context, phoneNumber string) (int, error) {
+	publicKeys, err := partner.GetPhoneNumberPublicKeys(ctx, phoneNumber)
 	if err != nil {
-		return nil, terrors.Propagate(err)
+		return 0, terrors.Propagate(err)
 	}
 
-	request.Header.Set("Content-Type", ContentTypeHeader)
-	request.Header.Set("User-Agent", UserAgentHeader)
+	return len(publicKeys), nil
+}
+
+// GetPhoneNumbersPublicKeys gets the public keys for all of the given phone numbers from the Verified SMS service in a
+// single request and returns them grouped by the phone number as it was passed in
+// Phone numbers are compared in E.164 form, so keys are grouped correctly even if Google formats the number
+// differently. If the Partner has a DefaultRegion, phone numbers may be in national format
+func (partner Partner) GetPhoneNumbersPublicKeys(ctx context.Context, phoneNumbers []string) (map[string][]string, error) {
+	// Several numbers passed in may resolve to the same one, which is only looked up once
+	resolvedNumbers := make([]string, 0, len(phoneNumbers))
+	numbersToLookUp := make([]string, 0, len(phoneNumbers))
+	lookedUp := map[string]bool{}
 
-	client, err := oauth2.GetHttpClient(ctx, partner.ServiceAccountJSONFile)
+	for _, phoneNumber := range phoneNumbers {
+		resolved, err := partner.resolvePhoneNumber(phoneNumber)
+		if err != nil {
+			return nil, terrors.Propagate(err)
+		}
+
+		if !lookedUp[resolved] {
+			numbersToLookUp = append(numbersToLookUp, resolved)
+			lookedUp[resolved] = true
+		}
+		resolvedNumbers = append(resolvedNumbers, resolved)
+	}
+
+	publicKeysByResolved, err := partner.getPublicKeysForPhoneNumbers(ctx, numbersToLookUp)
 	if err != nil {
 		return nil, terrors.Propagate(err)
 	}
 
-	httpResponse, err := client.Do(request)
+	publicKeysByNumber := make(map[string][]string, len(publicKeysByResolved))
+	for i, phoneNumber := range phoneNumbers {
+		if publicKeys, ok := publicKeysByResolved[resolvedNumbers[i]]; ok {
+			publicKeysByNumber[phoneNumber] = publicKeys
+		}
+	}
+
+	return publicKeysByNumber, nil
+}
+
+// getPublicKeysForPhoneNumbers gets the public keys for all of the given phone numbers in a single request to the
+// Verified SMS service and returns them keyed by the requested phone number
+// Phone numbers found in the Partner's PublicKeyCache aren't requested from the Verified SMS service
+func (partner Partner) getPublicKeysForPhoneNumbers(ctx context.Context, phoneNumbers []string) (map[string][]string, error) {
+	publicKeysByNumber := map[string][]string{}
+
+	phoneNumbersToFetch := phoneNumbers
+
+	if partner.PublicKeyCache != nil {
+		phoneNumbersToFetch = nil
+
+		for _, phoneNumber := range phoneNumbers {
+			publicKeys, ok := partner.PublicKeyCache.Get(phoneNumber)
+			if !ok {
+				phoneNumbersToFetch = append(phoneNumbersToFetch, phoneNumber)
+				continue
+			}
+
+			publicKeysByNumber[phoneNumber] = publicKeys
+		}
+
+		if len(phoneNumbersToFetch) == 0 {
+			return publicKeysByNumber, nil
+		}
+	}
+
+	var fetchedPublicKeys map[string][]string
+	var err error
+
+	if partner.PublicKeyCoalescer != nil {
+		fetchedPublicKeys, err = partner.PublicKeyCoalescer.lookup(ctx, phoneNumbersToFetch, partner.fetchPublicKeys)
+	} else {
+		fetchedPublicKeys, err = partner.fetchPublicKeys(ctx, phoneNumbersToFetch)
+	}
 	if err != nil {
 		return nil, terrors.Propagate(err)
 	}
 
-	if httpResponse.StatusCode < 200 || httpResponse.StatusCode > 299 {
-		return nil, terrors.InternalService(
-			terrors.ErrInternalService,
-			"bad response from Google: "+httpResponse.Status,
-			nil,
-		)
+	for _, phoneNumber := range phoneNumbersToFetch {
+		if publicKeys, ok := fetchedPublicKeys[phoneNumber]; ok {
+			publicKeysByNumber[phoneNumber] = publicKeys
+		}
 	}
 
-	response := verifiedSMSResponse{}
+	if partner.PublicKeyCache != nil {
+		for _, phoneNumber := range phoneNumbersToFetch {
+			partner.PublicKeyCache.Set(phoneNumber, publicKeysByNumber[phoneNumber])
+		}
+	}
+
+	return publicKeysByNumber, nil
+}
 
-	err = json.NewDecoder(httpResponse.Body).Decode(&response)
+// fetchPublicKeys gets the public keys for the given phone numbers from the Verified SMS service in a single request
+func (partner Partner) fetchPublicKeys(ctx context.Context, phoneNumbers []string) (map[string][]string, error) {
+	response := verifiedSMSResponse{}
 
+	_, err := partner.doRequest(ctx, http.MethodPost, apiGetPublicKeysPath, map[string][]string{
+		"phoneNumbers": phoneNumbers,
+	}, &response)
 	if err != nil {
 		return nil, terrors.Propagate(err)
 	}
 
-	var publicKeys []string
+	requestedNumbers := make(map[string][]string, len(phoneNumbers))
+	for _, phoneNumber := range phoneNumbers {
+		normalized := normalizePhoneNumber(phoneNumber)
+		requestedNumbers[normalized] = append(requestedNumbers[normalized], phoneNumber)
+	}
+
+	publicKeysByNumber := map[string][]string{}
 
 	for _, keys := range response.UserKeys {
-		if keys.PhoneNumber == phoneNumber {
-			publicKeys = append(publicKeys, keys.PublicKey)
+		for _, phoneNumber := range requestedNumbers[normalizePhoneNumber(keys.PhoneNumber)] {
+			publicKeysByNumber[phoneNumber] = append(publicKeysByNumber[phoneNumber], keys.PublicKey)
+		}
+	}
+
+	partner.logger().Info(ctx, "fetched public keys from Google", map[string]string{
+		"phone_number_count": strconv.Itoa(len(phoneNumbers)),
+		"public_key_count":   strconv.Itoa(len(response.UserKeys)),
+	})
+
+	return publicKeysByNumber, nil
+}
+
+// submitHashes submits the given message hashes to the Verified SMS service and returns whether each was stored, in
+// the same order as messagesToGoogle, along with Google's identifiers for the requests
+// Hashes are submitted in chunks of at most the Partner's MaxBatchSize, one after another. If a chunk can't be
+// submitted an error is returned, and hashes in earlier chunks will already have been stored
+// Duplicate hashes are only submitted, and have a result returned, once. Hashes Google doesn't report an error for, or
+// reports already exist, are considered stored
+func (partner Partner) submitHashes(ctx context.Context, messagesToGoogle []messageSubmissionToGoogle) ([]HashResult, []string, error) {
+	messagesToGoogle = dedupeMessages(messagesToGoogle)
+	if len(messagesToGoogle) == 0 {
+		return nil, nil, nil
+	}
+
+	hashResults := make([]HashResult, 0, len(messagesToGoogle))
+	var requestIDs []string
+
+	for i, requestStruct := range partner.submitRequests(messagesToGoogle) {
+		chunkResults, requestID, err := partner.submitHashesChunk(ctx, requestStruct)
+		if err != nil {
+			return nil, nil, terrors.Augment(err, "failed to submit hashes to Google", map[string]string{
+				"chunk":                strconv.Itoa(i),
+				"submitted_hash_count": strconv.Itoa(len(hashResults)),
+			})
+		}
+
+		hashResults = append(hashResults, chunkResults...)
+		if requestID != "" {
+			requestIDs = append(requestIDs, requestID)
 		}
 	}
 
-	return publicKeys, nil
+	return hashResults, requestIDs, nil
+}
+
+// submitRequests splits messagesToGoogle, which must already be deduplicated, into the requests submitHashes sends,
+// each of at most the Partner's MaxBatchSize hashes
+func (partner Partner) submitRequests(messagesToGoogle []messageSubmissionToGoogle) []batchSubmitRequest {
+	chunks := partner.chunkMessages(messagesToGoogle)
+	requests := make([]batchSubmitRequest, 0, len(chunks))
+
+	for _, chunk := range chunks {
+		requests = append(requests, batchSubmitRequest{
+			Messages: chunk,
+		})
+	}
+
+	return requests
+}
+
+// submitHashesChunk submits a single batch of message hashes to the Verified SMS service
+func (partner Partner) submitHashesChunk(ctx context.Context, requestStruct batchSubmitRequest) ([]HashResult, string, error) {
+	messagesToGoogle := requestStruct.Messages
+
+	response := batchSubmitResponse{}
+
+	metadata, err := partner.doRequest(ctx, http.MethodPost, apiSubmitHashesPath, requestStruct, &response)
+	if err != nil {
+		return nil, "", terrors.Propagate(err)
+	}
+
+	errorsByHash := map[string]googleError{}
+	for _, result := range response.Results {
+		// A hash which is already stored, e.g. because an earlier attempt succeeded, is as good as a new one
+		if result.Error != nil && result.Error.Status != "ALREADY_EXISTS" {
+			errorsByHash[result.Hash] = *result.Error
+		}
+	}
+
+	failedHashCount := 0
+
+	hashResults := make([]HashResult, 0, len(messagesToGoogle))
+	for _, message := range messagesToGoogle {
+		hashResult := HashResult{
+			Hash:    message.Hash,
+			Created: true,
+		}
+
+		if googleErr, ok := errorsByHash[message.Hash]; ok {
+			hashResult.Created = false
+			hashResult.Error = errorFromGoogleError("Google didn't store the hash: "+googleErr.Message, googleErr, map[string]string{
+				"google_status": googleErr.Status,
+			})
+			failedHashCount++
+		}
+
+		hashResults = append(hashResults, hashResult)
+	}
+
+	partner.logger().Info(ctx, "submitted hashes to Google", map[string]string{
+		"hash_count":        strconv.Itoa(len(messagesToGoogle)),
+		"failed_hash_count": strconv.Itoa(failedHashCount),
+		"request_id":        metadata.RequestID,
+	})
+
+	return hashResults, metadata.RequestID, nil
+}
+
+// deleteHashes deletes the given message hashes from the Verified SMS service, in chunks of at most the Partner's
+// MaxBatchSize
+func (partner Partner) deleteHashes(ctx context.Context, messagesToGoogle []messageSubmissionToGoogle) error {
+	messagesToGoogle = dedupeMessages(messagesToGoogle)
+
+	for i, chunk := range partner.chunkMessages(messagesToGoogle) {
+		requestStruct := batchSubmitRequest{
+			Messages: chunk,
+		}
+
+		_, err := partner.doRequest(ctx, http.MethodPost, apiDeleteHashesPath, requestStruct, nil)
+		if err != nil {
+			return terrors.Augment(err, "failed to delete hashes from Google", map[string]string{
+				"chunk": strconv.Itoa(i),
+			})
+		}
+
+		partner.logger().Info(ctx, "deleted hashes from Google", map[string]string{
+			"hash_count": strconv.Itoa(len(chunk)),
+		})
+	}
+
+	return nil
+}
+
+// chunkMessages splits messagesToGoogle into batches no larger than the Partner's MaxBatchSize
+func (partner Partner) chunkMessages(messagesToGoogle []messageSubmissionToGoogle) [][]messageSubmissionToGoogle {
+	maxBatchSize := partner.maxBatchSize()
+
+	var chunks [][]messageSubmissionToGoogle
+
+	for len(messagesToGoogle) > maxBatchSize {
+		chunks = append(chunks, messagesToGoogle[:maxBatchSize])
+		messagesToGoogle = messagesToGoogle[maxBatchSize:]
+	}
+
+	if len(messagesToGoogle) > 0 {
+		chunks = append(chunks, messagesToGoogle)
+	}
+
+	return chunks
+}
+
+// maxBatchSize returns the most entries the Partner sends to Google in a single batch request
+func (partner Partner) maxBatchSize() int {
+	if partner.MaxBatchSize < 1 {
+		return DefaultMaxBatchSize
+	}
+
+	return partner.MaxBatchSize
+}
+
+// dedupeMessages removes repeated hashes for the same agent while preserving the order in which they first appear,
+// these arise when iterations of a message collapse to the same content
+func dedupeMessages(messagesToGoogle []messageSubmissionToGoogle) []messageSubmissionToGoogle {
+	seen := make(map[messageSubmissionToGoogle]bool, len(messagesToGoogle))
+	deduped := make([]messageSubmissionToGoogle, 0, len(messagesToGoogle))
+
+	for _, message := range messagesToGoogle {
+		if seen[message] {
+			continue
+		}
+
+		seen[message] = true
+		deduped = append(deduped, message)
+	}
+
+	return deduped
+}
+
+// withOperationTimeout derives a context bounded by the Partner's OperationTimeout, context.WithTimeout keeps the
+// parent's deadline if it is earlier so the tighter of the two always wins
+func (partner Partner) withOperationTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if partner.OperationTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, partner.OperationTimeout)
+}
+
+// reportKeyErrors logs the public keys the message couldn't be hashed for and passes each of them to the Partner's
+// OnInvalidPublicKey
+func (partner Partner) reportKeyErrors(ctx context.Context, phoneNumber string, keyErrors map[string]error) {
+	if len(keyErrors) == 0 {
+		return
+	}
+
+	partner.logger().Error(ctx, "failed to hash the message for some public keys", map[string]string{
+		"failed_key_count": strconv.Itoa(len(keyErrors)),
+	})
+
+	if partner.OnInvalidPublicKey == nil {
+		return
+	}
+
+	for publicKey, err := range keyErrors {
+		partner.OnInvalidPublicKey(ctx, phoneNumber, publicKey, err)
+	}
+}
+
+// hashEncoding returns the base64 encoding the Partner encodes hashes with
+func (partner Partner) hashEncoding() *base64.Encoding {
+	if partner.HashEncoding == nil {
+		return base64.StdEncoding
+	}
+
+	return partner.HashEncoding
+}
+
+// getClock returns the clock the Partner tells the time with
+func (partner Partner) getClock() clock {
+	if partner.clock == nil {
+		return realClock{}
+	}
+
+	return partner.clock
+}
+
+// serviceAccountJSON returns the contents of the JSON keys file for the Partner's service account, falling back to the
+// deprecated ServiceAccountJSONFile field
+func (partner Partner) serviceAccountJSON() string {
+	if partner.ServiceAccountJSON != "" {
+		return partner.ServiceAccountJSON
+	}
+
+	return partner.ServiceAccountJSONFile
+}
+
+// getIterationsOfSMSMessage returns every variant of smsMessage that should be hashed using the Partner's Transformers
+func (partner Partner) getIterationsOfSMSMessage(smsMessage string) []string {
+	if partner.DisableDataMunging {
+		return []string{smsMessage}
+	}
+
+	transformers := partner.Transformers
+	if transformers == nil {
+		transformers = data_munging.DefaultTransformers()
+	}
+
+	iterations := data_munging.GetIterationsOfSMSMessage(smsMessage, transformers)
+
+	if partner.SegmentVariants {
+		seen := make(map[string]bool, len(iterations))
+		for _, iteration := range iterations {
+			seen[iteration] = true
+		}
+
+		for _, variant := range segmentVariants(smsMessage) {
+			if !seen[variant] {
+				seen[variant] = true
+				iterations = append(iterations, variant)
+			}
+		}
+	}
+
+	if partner.MaxIterations > 0 && len(iterations) > partner.MaxIterations {
+		iterations = iterations[:partner.MaxIterations]
+	}
+
+	return iterations
+}
+
+// getMessagesToGoogle hashes every iteration of smsMessage for every one of the users' public keys with each of the
+// agent's private keys, see getMessagesToGoogleForKey
+func (partner Partner) getMessagesToGoogle(publicKeys []string, agent *Agent, smsMessage string) ([]messageSubmissionToGoogle, map[string]error, error) {
+	err := agent.validate(partner.HashingOptions)
+	if err != nil {
+		return nil, nil, terrors.Propagate(err)
+	}
+
+	if len(agent.PreviousPrivateKeys) == 0 {
+		return partner.getMessagesToGoogleForKey(publicKeys, agent, smsMessage)
+	}
+
+	var messagesToGoogle []messageSubmissionToGoogle
+	keyErrors := map[string]error{}
+
+	var firstErr error
+
+	for _, privateKey := range append([]*ecdsa.PrivateKey{agent.PrivateKey}, agent.PreviousPrivateKeys...) {
+		messages, privateKeyErrors, err := partner.getMessagesToGoogleForKey(publicKeys, &Agent{
+			ID:         agent.ID,
+			PrivateKey: privateKey,
+		}, smsMessage)
+
+		for publicKey, keyErr := range privateKeyErrors {
+			keyErrors[publicKey] = keyErr
+		}
+
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		messagesToGoogle = append(messagesToGoogle, messages...)
+	}
+
+	if len(messagesToGoogle) == 0 && firstErr != nil {
+		return nil, keyErrors, terrors.Propagate(firstErr)
+	}
+
+	return messagesToGoogle, keyErrors, nil
+}
+
+// getMessagesToGoogleForKey hashes every iteration of smsMessage for every one of the users' public keys with the
+// agent's PrivateKey
+// The shared secret for each public key is derived once and reused for every iteration. Public keys are hashed
+// concurrently, bounded by the Partner's HashingConcurrency, but hashes are always returned in the same order
+// A public key which can't be parsed or hashed doesn't stop the others being hashed, its error is returned in the map
+// of public key to error instead. An error is only returned if the message couldn't be hashed for any public key, the
+// map of public key to error is still returned alongside it
+func (partner Partner) getMessagesToGoogleForKey(publicKeys []string, agent *Agent, smsMessage string) ([]messageSubmissionToGoogle, map[string]error, error) {
+	smsMessages := partner.getIterationsOfSMSMessage(smsMessage)
+
+	parsedPublicKeys := make([]*ecdsa.PublicKey, len(publicKeys))
+	keyErrs := make([]error, len(publicKeys))
+
+	for i, publicKeyString := range publicKeys {
+		publicKey, err := hashing.ParsePublicKey(publicKeyString)
+		if err != nil {
+			keyErrs[i] = terrors.Propagate(err)
+			continue
+		}
+
+		parsedPublicKeys[i] = publicKey
+	}
+
+	messagesByKey := make([][]messageSubmissionToGoogle, len(publicKeys))
+	hashEncoding := partner.hashEncoding()
+
+	concurrency := partner.HashingConcurrency
+	if concurrency < 1 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	if concurrency > len(publicKeys) {
+		concurrency = len(publicKeys)
+	}
+
+	nextPublicKey := make(chan int)
+
+	go func() {
+		defer close(nextPublicKey)
+
+		for i := range parsedPublicKeys {
+			if parsedPublicKeys[i] != nil {
+				nextPublicKey <- i
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+
+	// Each worker only writes the entries for the keys it takes, so the slices don't need locking
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range nextPublicKey {
+				messages, err := partner.hashForPublicKey(parsedPublicKeys[i], agent, smsMessages, hashEncoding)
+				if err != nil {
+					keyErrs[i] = err
+					continue
+				}
+
+				messagesByKey[i] = messages
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	messagesToGoogle := make([]messageSubmissionToGoogle, 0, len(publicKeys)*len(smsMessages))
+	keyErrors := map[string]error{}
+
+	var firstErr error
+
+	for i, publicKey := range publicKeys {
+		if keyErrs[i] != nil {
+			keyErrors[publicKey] = keyErrs[i]
+			if firstErr == nil {
+				firstErr = keyErrs[i]
+			}
+			continue
+		}
+
+		messagesToGoogle = append(messagesToGoogle, messagesByKey[i]...)
+	}
+
+	if len(messagesToGoogle) == 0 && firstErr != nil {
+		return nil, keyErrors, terrors.Augment(firstErr, "failed to hash the message for any public key", map[string]string{
+			"public_key_count": strconv.Itoa(len(publicKeys)),
+		})
+	}
+
+	return messagesToGoogle, keyErrors, nil
+}
+
+// hashForPublicKey returns the messages to submit to Google for every iteration of an SMS sent by agent to the device
+// with publicKey, deriving their shared secret once with the Partner's HashingOptions
+func (partner Partner) hashForPublicKey(publicKey *ecdsa.PublicKey, agent *Agent, smsMessages []string, hashEncoding *base64.Encoding) ([]messageSubmissionToGoogle, error) {
+	sharedSecret, err := partner.HashingOptions.DeriveSharedSecret(publicKey, agent.PrivateKey)
+	if err != nil {
+		return nil, terrors.Propagate(err)
+	}
+
+	messages := make([]messageSubmissionToGoogle, 0, len(smsMessages))
+
+	for _, smsMessageEntry := range smsMessages {
+		hash, err := partner.HashingOptions.GetHashForSMSMessageWithSharedSecret(sharedSecret, []byte(smsMessageEntry))
+		if err != nil {
+			return nil, terrors.Propagate(err)
+		}
+
+		messages = append(messages, messageSubmissionToGoogle{
+			Hash:    hashEncoding.EncodeToString(hash),
+			AgentId: agent.ID,
+		})
+	}
+
+	return messages, nil
 }
 
 type verifiedSMSResponse struct {
@@ -175,11 +1240,28 @@ type verifiedSMSResponseUserKeys struct {
 	PublicKey   string `json:"publicKey"`
 }
 
+// messageSubmissionToGoogle is a message in the messages:batchCreate, messages:batchDelete and messages:batchGet
+// requests. Google only accepts the hash and the agent, there's no field for when the message was created or should
+// expire
+// Neither field is omitted when empty, as Google requires both and an empty agent ID is caught before submitting
 type messageSubmissionToGoogle struct {
 	Hash    string `json:"hash"`
 	AgentId string `json:"agentId"`
 }
 
+// batchSubmitRequest is the body of the messages:batchCreate, messages:batchDelete and messages:batchGet requests
+// Google doesn't document a client-supplied request ID for these endpoints and rejects fields it doesn't recognise, so
+// submissions don't carry an idempotency token. Retrying a submission resubmits the same hashes, which identify the
+// messages, and any Google reports as ALREADY_EXISTS are treated as stored
 type batchSubmitRequest struct {
 	Messages []messageSubmissionToGoogle `json:"messages"`
 }
+
+type batchSubmitResponse struct {
+	Results []batchSubmitResult `json:"results"`
+}
+
+type batchSubmitResult struct {
+	Hash  string       `json:"hash"`
+	Error *googleError `json:"error"`
+}
@@ -0,0 +1,394 @@
+package verifiedsms
+
+import (
+	"bytes"
+	"context"
+	"crypto/elliptic"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/monzo/verifiedsms/hashing"
+)
+
+func TestMarkSMSAsVerifiedTreatsAlreadyExistingHashesAsStored(t *testing.T) {
+	phoneNumber := "+447700900001"
+	google := newFakeGoogle(map[string][]string{
+		phoneNumber: {newTestUserKey(t)},
+	})
+
+	partner := newTestPartner(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != apiSubmitHashesPath {
+			google.ServeHTTP(w, r)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "requestId") {
+			t.Errorf("expected no request ID in the submission, got %s", body)
+		}
+
+		request := batchSubmitRequest{}
+		_ = json.Unmarshal(body, &request)
+
+		response := batchSubmitResponse{}
+		for _, message := range request.Messages {
+			response.Results = append(response.Results, batchSubmitResult{
+				Hash: message.Hash,
+				Error: &googleError{
+					Code:    409,
+					Message: "message already exists",
+					Status:  "ALREADY_EXISTS",
+				},
+			})
+		}
+
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+
+	result, err := partner.MarkSMSAsVerifiedResult(context.Background(), phoneNumber, newTestAgent(t), "Your code is 1234")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if result.Status != Verified || result.FailedHashCount() != 0 {
+		t.Errorf("expected every hash to be treated as stored, got %+v", result)
+	}
+}
+
+func TestMarkSMSAsVerifiedIsPartialWhenAKeyCantBeHashed(t *testing.T) {
+	phoneNumber := "+447700900001"
+	invalidKey := "bm90IGEga2V5"
+	google := newFakeGoogle(map[string][]string{
+		phoneNumber: {newTestUserKey(t), invalidKey},
+	})
+
+	partner := newTestPartner(t, google)
+
+	result, err := partner.MarkSMSAsVerifiedResult(context.Background(), phoneNumber, newTestAgent(t), "Your code is 1234")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if result.Status != PartiallyVerified {
+		t.Errorf("expected %s, got %s", PartiallyVerified, result.Status)
+	}
+
+	if _, ok := result.KeyErrors[invalidKey]; !ok || len(result.KeyErrors) != 1 {
+		t.Errorf("expected only the invalid key to have failed, got %v", result.KeyErrors)
+	}
+
+	if result.FailedHashCount() != 0 || result.HashCount == 0 {
+		t.Errorf("expected the hashes for the valid key to be stored, got %+v", result)
+	}
+}
+
+func TestMarkSMSAsVerifiedFailsWhenNoKeyCanBeHashed(t *testing.T) {
+	phoneNumber := "+447700900001"
+	google := newFakeGoogle(map[string][]string{
+		phoneNumber: {"bm90IGEga2V5"},
+	})
+
+	partner := newTestPartner(t, google)
+
+	_, err := partner.MarkSMSAsVerifiedResult(context.Background(), phoneNumber, newTestAgent(t), "Your code is 1234")
+	if err == nil {
+		t.Fatalf("expected an error when the message can't be hashed for any key")
+	}
+
+	if google.requestCount(apiSubmitHashesPath) != 0 {
+		t.Errorf("expected nothing to be submitted to Google")
+	}
+}
+
+func TestComputeVerificationHashesUsesHashingOptions(t *testing.T) {
+	phoneNumber := "+447700900001"
+	google := newFakeGoogle(map[string][]string{
+		phoneNumber: {newTestUserKey(t)},
+	})
+
+	partner := newTestPartner(t, google)
+	partner.DisableDataMunging = true
+	agent := newTestAgent(t)
+
+	computeHash := func(options hashing.Options) []byte {
+		partner.HashingOptions = options
+
+		hashes, err := partner.ComputeVerificationHashes(context.Background(), phoneNumber, agent, "Your code is 1234")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(hashes) != 1 {
+			t.Fatalf("expected one hash, got %v", hashes)
+		}
+
+		hash, err := base64.StdEncoding.DecodeString(hashes[0])
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		return hash
+	}
+
+	defaultHash := computeHash(hashing.Options{})
+	shortHash := computeHash(hashing.Options{HashLength: 16})
+	saltedHash := computeHash(hashing.Options{HashLength: 16, Salt: []byte("salt")})
+	infoHash := computeHash(hashing.Options{HashLength: 16, Info: func(smsMessage []byte) []byte {
+		return append([]byte("prefix:"), smsMessage...)
+	}})
+
+	// HKDF's output for a shorter length is a prefix of its output for a longer one
+	if !bytes.Equal(shortHash, defaultHash[:16]) {
+		t.Errorf("expected the 16 byte hash to be a prefix of the default hash, got %x and %x", shortHash, defaultHash)
+	}
+
+	if bytes.Equal(saltedHash, shortHash) {
+		t.Errorf("expected the salt to change the hash")
+	}
+
+	if bytes.Equal(infoHash, shortHash) || bytes.Equal(infoHash, saltedHash) {
+		t.Errorf("expected the info to change the hash")
+	}
+}
+
+func TestHashingOptionsCurvesApplyToTheAgent(t *testing.T) {
+	partner := newTestPartner(t, newFakeGoogle(nil))
+	partner.HashingOptions = hashing.Options{
+		Curves: []elliptic.Curve{elliptic.P256()},
+	}
+
+	agent := newTestAgent(t)
+
+	_, err := partner.ComputeVerificationHashes(context.Background(), "+447700900001", agent, "Your code is 1234")
+	if !terrors.Is(err, terrors.ErrPreconditionFailed) {
+		t.Errorf("expected a P-384 agent to be rejected when only P-256 is approved, got %v", err)
+	}
+}
+
+func TestMaxIterationsKeepsTheOriginalAndTrimmedMessage(t *testing.T) {
+	smsMessage := " Café  £5 ✅ see https://monzo.me/x/\r\nThanks "
+
+	partner := Partner{}
+	if iterations := partner.getIterationsOfSMSMessage(smsMessage); len(iterations) <= 2 {
+		t.Fatalf("expected the message to have more than 2 iterations without a cap, got %q", iterations)
+	}
+
+	partner.MaxIterations = 2
+
+	iterations := partner.getIterationsOfSMSMessage(smsMessage)
+
+	expected := []string{smsMessage, "Café  £5 ✅ see https://monzo.me/x/\r\nThanks"}
+	if !reflect.DeepEqual(iterations, expected) {
+		t.Errorf("expected %q, got %q", expected, iterations)
+	}
+}
+
+func TestMaxIterationsCapsTheSubmittedHashes(t *testing.T) {
+	phoneNumber := "+447700900001"
+	google := newFakeGoogle(map[string][]string{
+		phoneNumber: {newTestUserKey(t), newTestUserKey(t)},
+	})
+
+	partner := newTestPartner(t, google)
+	partner.MaxIterations = 3
+
+	result, err := partner.MarkSMSAsVerifiedResult(context.Background(), phoneNumber, newTestAgent(t), " Café  £5 ✅ see https://monzo.me/x/\r\nThanks ")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if result.HashCount != 6 || len(hashes(google.created)) != 6 {
+		t.Errorf("expected 3 hashes for each of the 2 keys, got %d and %d submitted", result.HashCount, len(hashes(google.created)))
+	}
+}
+
+func TestDisableDataMungingSubmitsOneHashPerKey(t *testing.T) {
+	phoneNumber := "+447700900001"
+	google := newFakeGoogle(map[string][]string{
+		phoneNumber: {newTestUserKey(t), newTestUserKey(t), newTestUserKey(t)},
+	})
+
+	partner := newTestPartner(t, google)
+	partner.DisableDataMunging = true
+
+	smsMessage := " Café  £5 ✅ see https://monzo.me/x/\r\nThanks "
+
+	result, err := partner.MarkSMSAsVerifiedResult(context.Background(), phoneNumber, newTestAgent(t), smsMessage)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if result.HashCount != 3 || len(hashes(google.created)) != 3 {
+		t.Errorf("expected one hash for each of the 3 keys, got %d and %d submitted", result.HashCount, len(hashes(google.created)))
+	}
+
+	if iterations := partner.getIterationsOfSMSMessage(smsMessage); !reflect.DeepEqual(iterations, []string{smsMessage}) {
+		t.Errorf("expected only the exact message to be hashed, got %q", iterations)
+	}
+}
+
+func TestDedupeMessages(t *testing.T) {
+	messages := []messageSubmissionToGoogle{
+		{Hash: "a", AgentId: "agent-1"},
+		{Hash: "b", AgentId: "agent-1"},
+		{Hash: "a", AgentId: "agent-1"},
+		{Hash: "a", AgentId: "agent-2"},
+		{Hash: "b", AgentId: "agent-1"},
+	}
+
+	expected := []messageSubmissionToGoogle{
+		{Hash: "a", AgentId: "agent-1"},
+		{Hash: "b", AgentId: "agent-1"},
+		{Hash: "a", AgentId: "agent-2"},
+	}
+
+	if deduped := dedupeMessages(messages); !reflect.DeepEqual(deduped, expected) {
+		t.Errorf("expected %v, got %v", expected, deduped)
+	}
+}
+
+func TestMarkSMSAsVerifiedSubmitsDuplicateHashesOnce(t *testing.T) {
+	phoneNumber := "+447700900001"
+	google := newFakeGoogle(map[string][]string{
+		phoneNumber: {newTestUserKey(t)},
+	})
+
+	partner := newTestPartner(t, google)
+	partner.DisableDataMunging = true
+
+	// A previous key which is the same as the current one derives exactly the same hashes
+	agent := newTestAgent(t)
+	agent.PreviousPrivateKeys = append(agent.PreviousPrivateKeys, agent.PrivateKey)
+
+	_, err := partner.MarkSMSAsVerifiedResult(context.Background(), phoneNumber, agent, "Your code is 1234")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if submitted := hashes(google.created); len(submitted) != 1 {
+		t.Errorf("expected the duplicate hash to be submitted once, got %v", submitted)
+	}
+}
+
+func TestComputeVerificationHashesMatchesTheSubmittedHashes(t *testing.T) {
+	phoneNumber := "+447700900001"
+	google := newFakeGoogle(map[string][]string{
+		phoneNumber: {newTestUserKey(t)},
+	})
+
+	partner := newTestPartner(t, google)
+
+	// The previous key repeats every hash of the current one, and the munged variants of a plain message collide
+	agent := newTestAgent(t)
+	agent.PreviousPrivateKeys = append(agent.PreviousPrivateKeys, agent.PrivateKey)
+	smsMessage := "Your code is 1234"
+
+	computed, err := partner.ComputeVerificationHashes(context.Background(), phoneNumber, agent, smsMessage)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := partner.MarkSMSAsVerified(context.Background(), phoneNumber, agent, smsMessage); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if submitted := hashes(google.created); !reflect.DeepEqual(computed, submitted) {
+		t.Errorf("expected the computed hashes to match the submitted hashes, got %v and %v", computed, submitted)
+	}
+}
+
+func TestUnverifySMSDeletesTheSubmittedHashes(t *testing.T) {
+	phoneNumber := "+447700900001"
+	google := newFakeGoogle(map[string][]string{
+		phoneNumber: {newTestUserKey(t), newTestUserKey(t)},
+	})
+
+	partner := newTestPartner(t, google)
+	agent := newTestAgent(t)
+	smsMessage := " Café  £5 ✅ see https://monzo.me/x/\r\nThanks "
+
+	if _, err := partner.MarkSMSAsVerified(context.Background(), phoneNumber, agent, smsMessage); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := partner.UnverifySMS(context.Background(), phoneNumber, agent, smsMessage); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	created, deleted := hashes(google.created), hashes(google.deleted)
+	if len(created) == 0 || !reflect.DeepEqual(deleted, created) {
+		t.Errorf("expected the deleted hashes to match the created hashes, got %v and %v", created, deleted)
+	}
+
+	for _, request := range google.deleted {
+		for _, message := range request.Messages {
+			if message.AgentId != agent.ID {
+				t.Errorf("expected every deleted hash to be for %s, got %s", agent.ID, message.AgentId)
+			}
+		}
+	}
+}
+
+func TestBatchMarkSMSAsVerifiedResolvesNationalNumbers(t *testing.T) {
+	google := newFakeGoogle(map[string][]string{
+		"+447700900001": {newTestUserKey(t)},
+	})
+
+	partner := newTestPartner(t, google)
+	partner.DefaultRegion = "GB"
+
+	verified, errs, err := partner.BatchMarkSMSAsVerified(context.Background(), map[string]string{
+		"07700 900001":  "Your code is 1234",
+		"+447700900001": "Your code is 1234",
+		"07700900002":   "Your code is 5678",
+	}, newTestAgent(t))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]bool{
+		"07700 900001":  true,
+		"+447700900001": true,
+		"07700900002":   false,
+	}
+	if !reflect.DeepEqual(verified, expected) {
+		t.Errorf("expected %v, got %v", expected, verified)
+	}
+
+	if len(errs) != 0 {
+		t.Errorf("expected no errors, got %v", errs)
+	}
+}
+
+func TestGetPhoneNumbersPublicKeysResolvesNationalNumbers(t *testing.T) {
+	publicKey := newTestUserKey(t)
+	google := newFakeGoogle(map[string][]string{
+		"+447700900001": {publicKey},
+	})
+
+	partner := newTestPartner(t, google)
+	partner.DefaultRegion = "GB"
+
+	publicKeysByNumber, err := partner.GetPhoneNumbersPublicKeys(context.Background(), []string{"07700 900001", "+447700900001", "07700900002"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string][]string{
+		"07700 900001":  {publicKey},
+		"+447700900001": {publicKey},
+	}
+	if !reflect.DeepEqual(publicKeysByNumber, expected) {
+		t.Errorf("expected %v, got %v", expected, publicKeysByNumber)
+	}
+
+	if _, err := partner.GetPhoneNumbersPublicKeys(context.Background(), []string{"not a number"}); !terrors.Is(err, terrors.ErrBadRequest) {
+		t.Errorf("expected an invalid number to be a bad request, got %v", err)
+	}
+}
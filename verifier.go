@@ -0,0 +1,41 @@
+package verifiedsms
+
+import (
+	"context"
+)
+
+// Verifier is implemented by Partner, code which verifies SMS can depend on it rather than on Partner so a fake can be
+// substituted in tests
+// It has every method which calls Google apart from the raw escape hatches, DoRawRequest, ComputeSubmissionBodies and
+// GetPhoneNumbersPublicKeysRaw, and the methods which return other clients, AgentPages and WithAgent
+type Verifier interface {
+	MarkSMSAsVerified(ctx context.Context, phoneNumber string, agent *Agent, smsMessage string) (bool, error)
+	MarkSMSAsVerifiedStrict(ctx context.Context, phoneNumber string, agent *Agent, smsMessage string) error
+	MarkSMSAsVerifiedResult(ctx context.Context, phoneNumber string, agent *Agent, smsMessage string) (*VerificationResult, error)
+	MarkSMSAsVerifiedBytes(ctx context.Context, phoneNumber string, agent *Agent, smsMessage []byte) (*VerificationResult, error)
+	MarkSMSAsVerifiedForAgents(ctx context.Context, phoneNumber string, agents []*Agent, smsMessage string) (*VerificationResult, error)
+	MarkSMSAsVerifiedWithKeys(ctx context.Context, publicKeys []string, agent *Agent, smsMessage string) (*VerificationResult, error)
+	MarkSMSAsVerifiedForNumbers(ctx context.Context, phoneNumbers []string, agent *Agent, smsMessage string) (map[string]VerificationResult, error)
+	MarkSMSAsVerifiedForNumbersWithSummary(ctx context.Context, phoneNumbers []string, agent *Agent, smsMessage string) (map[string]VerificationResult, *BatchSummary, error)
+	MarkSMSAsVerifiedStream(ctx context.Context, phoneNumbers <-chan string, agent *Agent, smsMessage string) <-chan NumberResult
+	BatchMarkSMSAsVerified(ctx context.Context, smsMessages map[string]string, agent *Agent) (map[string]bool, map[string]error, error)
+	BatchMarkSMSAsVerifiedWithSummary(ctx context.Context, smsMessages map[string]string, agent *Agent) (map[string]bool, map[string]error, *BatchSummary, error)
+	Send(ctx context.Context, request SendRequest) (*VerificationResult, error)
+	SendWithFallback(ctx context.Context, phoneNumber string, agent *Agent, smsMessage string, fallback FallbackSender) (SendPath, error)
+	ComputeVerificationHashes(ctx context.Context, phoneNumber string, agent *Agent, smsMessage string) ([]string, error)
+	UnverifySMS(ctx context.Context, phoneNumber string, agent *Agent, smsMessage string) error
+	DeleteVerifiedMessages(ctx context.Context, agent *Agent, smsMessage string, phoneNumber string) error
+	HashesExist(ctx context.Context, agent *Agent, hashes []string) (map[string]bool, error)
+	MessageHashesExist(ctx context.Context, phoneNumber string, agent *Agent, smsMessage string) (map[string]bool, error)
+	GetPhoneNumberPublicKeys(ctx context.Context, phoneNumber string) ([]string, error)
+	GetPhoneNumbersPublicKeys(ctx context.Context, phoneNumbers []string) (map[string][]string, error)
+	CountPhoneNumberKeys(ctx context.Context, phoneNumber string) (int, error)
+	CheckSupport(ctx context.Context, phoneNumbers []string) (map[string]bool, error)
+	GetPhoneNumberUserKeys(ctx context.Context, phoneNumber string) ([]UserKey, error)
+	EnableUserKeys(ctx context.Context, publicKeys map[string]string) (map[string]error, error)
+	GetAgentStatus(ctx context.Context, agentID string) (*AgentStatus, error)
+	ListAgents(ctx context.Context) ([]AgentStatus, error)
+	Ping(ctx context.Context) error
+}
+
+var _ Verifier = Partner{}